@@ -1,8 +1,14 @@
 package xorm
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -1603,6 +1609,2358 @@ func testBool(engine *Engine, t *testing.T) {
 	}
 }
 
+type UserGroup struct {
+	UserId  int64 `xorm:"pk"`
+	GroupId int64 `xorm:"pk"`
+	Role    string
+}
+
+type UserGroupKey struct {
+	UserId  int64
+	GroupId int64
+}
+
+func testCompositeKey(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(UserGroup))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	err = engine.CreateTables(new(UserGroup))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Insert(&UserGroup{1, 1, "admin"}, &UserGroup{1, 2, "member"},
+		&UserGroup{2, 1, "member"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Insert(&UserGroup{1, 2, "admin"})
+	if err == nil {
+		err = errors.New("insert duplicated composite key but no error")
+		t.Error(err)
+		panic(err)
+	}
+
+	ug := new(UserGroup)
+	has, err := engine.Id(PK{1, 2}).Get(ug)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if !has || ug.Role != "member" {
+		err = errors.New(fmt.Sprintf("get composite key failed: %v", ug))
+		t.Error(err)
+		panic(err)
+	}
+
+	cnt, err := engine.Id(PK{1, 2}).Update(&UserGroup{Role: "owner"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if cnt != 1 {
+		err = errors.New("update composite key should affect one record")
+		t.Error(err)
+		panic(err)
+	}
+
+	ugs := make(map[UserGroupKey]*UserGroup)
+	err = engine.Find(&ugs)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(ugs) != 3 || ugs[UserGroupKey{1, 2}] == nil || ugs[UserGroupKey{1, 2}].Role != "owner" {
+		err = errors.New(fmt.Sprintf("find map with composite key failed: %v", ugs))
+		t.Error(err)
+		panic(err)
+	}
+
+	cnt, err = engine.Id(PK{1, 2}).Delete(new(UserGroup))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if cnt != 1 {
+		err = errors.New("delete composite key should affect one record")
+		t.Error(err)
+		panic(err)
+	}
+
+	has, err = engine.Id(PK{1, 2}).Get(new(UserGroup))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if has {
+		err = errors.New("composite key record should be deleted")
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type StringPK struct {
+	Id   string `xorm:"varchar(36)"`
+	Name string
+}
+
+type StringPKRef struct {
+	Id    int64
+	Owner StringPK `xorm:"owner_id varchar(36)"`
+}
+
+func testStringPK(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(StringPK), new(StringPKRef))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	err = engine.CreateTables(new(StringPK), new(StringPKRef))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Insert(&StringPK{"6ba7b810-9dad-11d1-80b4-00c04fd430c8", "lunny"},
+		&StringPK{"6ba7b811-9dad-11d1-80b4-00c04fd430c8", "xlw"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	sp := new(StringPK)
+	has, err := engine.Id("6ba7b811-9dad-11d1-80b4-00c04fd430c8").Get(sp)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if !has || sp.Name != "xlw" {
+		err = errors.New(fmt.Sprintf("get string pk failed: %v", sp))
+		t.Error(err)
+		panic(err)
+	}
+
+	cnt, err := engine.Id("6ba7b811-9dad-11d1-80b4-00c04fd430c8").Update(&StringPK{Name: "xlw2"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if cnt != 1 {
+		err = errors.New("update string pk should affect one record")
+		t.Error(err)
+		panic(err)
+	}
+
+	sps := make(map[string]*StringPK)
+	err = engine.Find(&sps)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(sps) != 2 || sps["6ba7b811-9dad-11d1-80b4-00c04fd430c8"] == nil ||
+		sps["6ba7b811-9dad-11d1-80b4-00c04fd430c8"].Name != "xlw2" {
+		err = errors.New(fmt.Sprintf("find map with string pk failed: %v", sps))
+		t.Error(err)
+		panic(err)
+	}
+
+	ref := &StringPKRef{Owner: StringPK{Id: "6ba7b810-9dad-11d1-80b4-00c04fd430c8"}}
+	_, err = engine.Insert(ref)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	ref2 := new(StringPKRef)
+	has, err = engine.Id(ref.Id).Get(ref2)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if !has || ref2.Owner.Name != "lunny" {
+		err = errors.New(fmt.Sprintf("cascade get with string pk failed: %v", ref2))
+		t.Error(err)
+		panic(err)
+	}
+
+	cnt, err = engine.Id("6ba7b811-9dad-11d1-80b4-00c04fd430c8").Delete(new(StringPK))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if cnt != 1 {
+		err = errors.New("delete string pk should affect one record")
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type Uint64Id struct {
+	Id   uint64
+	Name string
+}
+
+func testUint64Id(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(Uint64Id))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	err = engine.CreateTables(new(Uint64Id))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	u := &Uint64Id{Name: "lunny"}
+	_, err = engine.Insert(u)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if u.Id == 0 {
+		err = errors.New("insert uint64 id should write back the id")
+		t.Error(err)
+		panic(err)
+	}
+
+	us := make(map[uint64]Uint64Id)
+	err = engine.Find(&us)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(us) != 1 || us[u.Id].Name != "lunny" {
+		err = errors.New(fmt.Sprintf("find map with uint64 id failed: %v", us))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+func testContext(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(Uint64Id))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	err = engine.CreateTables(new(Uint64Id))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = engine.Context(ctx).Insert(&Uint64Id{Name: "lunny"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	users := make([]Uint64Id, 0)
+	err = engine.Context(ctx).Find(&users)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(users) != 1 {
+		err = errors.New("find with a context should return one record")
+		t.Error(err)
+		panic(err)
+	}
+
+	cancel()
+
+	err = engine.Context(ctx).Find(&users)
+	if err == nil {
+		err = errors.New("find with a cancelled context but no error")
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Context(ctx).Exec("delete from uint64_id")
+	if err == nil {
+		err = errors.New("exec with a cancelled context but no error")
+		t.Error(err)
+		panic(err)
+	}
+
+	err = engine.Context(ctx).Iterate(new(Uint64Id), func(i int, bean interface{}) error {
+		return nil
+	})
+	if err == nil {
+		err = errors.New("iterate with a cancelled context but no error")
+		t.Error(err)
+		panic(err)
+	}
+
+	session := engine.NewSession()
+	defer session.Close()
+	err = session.Context(ctx).Begin()
+	if err == nil {
+		err = errors.New("begin with a cancelled context but no error")
+		t.Error(err)
+		panic(err)
+	}
+
+	engine.QueryTimeout = time.Minute
+	defer func() {
+		engine.QueryTimeout = 0
+	}()
+	_, err = engine.Query("select * from uint64_id")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type MigrateUser struct {
+	Id   int64
+	Name string
+}
+
+func testMigrate(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(MigrateUser), "test_migration")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	migrator := NewMigrator(engine, &Migration{
+		Version: 2,
+		Name:    "add_users",
+		UpSql:   "INSERT INTO migrate_user (name) VALUES ('lunny;xlw');\n-- comment;\nINSERT INTO migrate_user (name) VALUES ('xlw');",
+		DownSql: "DELETE FROM migrate_user WHERE name = 'lunny;xlw'; DELETE FROM migrate_user WHERE name = 'xlw'",
+	}, &Migration{
+		Version: 1,
+		Name:    "create_user",
+		Up: func(session *Session) error {
+			return session.CreateTable(new(MigrateUser))
+		},
+		Down: func(session *Session) error {
+			return session.DropTable(new(MigrateUser))
+		},
+	})
+	migrator.TableName = "test_migration"
+
+	err = migrator.Migrate()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	status, err := migrator.Status()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(status) != 2 || !status[0].Applied || !status[1].Applied || status[0].Version != 1 {
+		err = errors.New(fmt.Sprintf("migrate status is wrong: %v", status))
+		t.Error(err)
+		panic(err)
+	}
+
+	results, err := engine.Query("select name from migrate_user where name = ?", "lunny;xlw")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(results) != 1 {
+		err = errors.New(fmt.Sprintf("migrate sql is not executed: %v", results))
+		t.Error(err)
+		panic(err)
+	}
+
+	// an unknown applied version is found before any migration is rollbacked
+	_, err = engine.Table("test_migration").Insert(&migrationRecord{Version: 99, Name: "unknown", AppliedAt: time.Now()})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = migrator.MigrateTo(1)
+	if err == nil {
+		err = errors.New("migrate with an unknown applied version but no error")
+		t.Error(err)
+		panic(err)
+	}
+	cnt, err := engine.Count(new(MigrateUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if cnt != 2 {
+		err = errors.New(fmt.Sprintf("should not rollback any migration but %v users", cnt))
+		t.Error(err)
+		panic(err)
+	}
+	_, err = engine.Table("test_migration").Id(99).Delete(new(migrationRecord))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	err = migrator.Rollback()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	version, err := migrator.Version()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if version != 1 {
+		err = errors.New(fmt.Sprintf("version should be 1 after rollback but %v", version))
+		t.Error(err)
+		panic(err)
+	}
+
+	cnt, err = engine.Count(new(MigrateUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if cnt != 0 {
+		err = errors.New("rollback sql is not executed")
+		t.Error(err)
+		panic(err)
+	}
+
+	// the pending migration 2 is not applied by RollbackTo
+	err = migrator.RollbackTo(2)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	version, err = migrator.Version()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if version != 1 {
+		err = errors.New(fmt.Sprintf("version should be 1 after rollback to 2 but %v", version))
+		t.Error(err)
+		panic(err)
+	}
+
+	migrator.Add(&Migration{
+		Version: 3,
+		Name:    "failed",
+		Up: func(session *Session) error {
+			_, err := session.Insert(&MigrateUser{Name: "failed"})
+			if err != nil {
+				return err
+			}
+			return errors.New("failed migration")
+		},
+	})
+	err = migrator.Migrate()
+	if err == nil {
+		err = errors.New("migrate a failed migration but no error")
+		t.Error(err)
+		panic(err)
+	}
+
+	version, err = migrator.Version()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if version != 2 {
+		err = errors.New(fmt.Sprintf("version should be 2 after failed migration but %v", version))
+		t.Error(err)
+		panic(err)
+	}
+
+	cnt, err = engine.Where("name = ?", "failed").Count(new(MigrateUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if cnt != 0 {
+		err = errors.New("failed migration should be rollbacked")
+		t.Error(err)
+		panic(err)
+	}
+
+	err = migrator.MigrateTo(0)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	exist, err := engine.IsTableExist(new(MigrateUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if exist {
+		err = errors.New("table should be dropped after migrate to 0")
+		t.Error(err)
+		panic(err)
+	}
+
+	empty := NewMigrator(engine, &Migration{Version: 1, Name: "empty", UpSql: "-- nothing"})
+	empty.TableName = "test_migration"
+	err = empty.Migrate()
+	if err == nil {
+		err = errors.New("migrate an empty migration but no error")
+		t.Error(err)
+		panic(err)
+	}
+	version, err = empty.Version()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if version != 0 {
+		err = errors.New(fmt.Sprintf("empty migration should not be applied but version %v", version))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type SyncAlter struct {
+	Id    int64
+	Name  string `xorm:"index"`
+	Old   string
+	Email string `xorm:"unique"`
+}
+
+type SyncAlterNull struct {
+	Id   int64
+	Name string
+}
+
+func testSyncAlter(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(SyncAlter), new(SyncAlterNull))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Exec("CREATE TABLE sync_alter (id INTEGER PRIMARY KEY, name TEXT NULL, old TEXT NULL)")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = engine.Exec("CREATE INDEX IDX_sync_alter_old ON sync_alter (old)")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = engine.Exec("CREATE INDEX manual_old ON sync_alter (old, name)")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	sqls, err := engine.SyncAlterDryRun(new(SyncAlter))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	fmt.Println(sqls)
+	if len(sqls) != 4 || !strings.Contains(sqls[1], "IDX_sync_alter_old") {
+		err = errors.New(fmt.Sprintf("sync alter plan is wrong: %v", sqls))
+		t.Error(err)
+		panic(err)
+	}
+
+	exist, err := engine.IsTableExist(new(SyncAlter))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	session := engine.NewSession()
+	defer session.Close()
+	has, err := session.isColumnExist("sync_alter", "email")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if !exist || has {
+		err = errors.New("sync alter dry run should not execute")
+		t.Error(err)
+		panic(err)
+	}
+
+	sqls, err = engine.SyncAlter(new(SyncAlter))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(sqls) != 4 {
+		err = errors.New(fmt.Sprintf("sync alter executed wrong sqls: %v", sqls))
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Insert(&SyncAlter{Name: "lunny", Email: "lunny@xorm.io"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	sqls, err = engine.SyncAlterDryRun(new(SyncAlter))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(sqls) != 0 {
+		err = errors.New(fmt.Sprintf("synced table should have no plan: %v", sqls))
+		t.Error(err)
+		panic(err)
+	}
+
+	has, err = session.isIndexExist("sync_alter", "old", false)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if has {
+		err = errors.New("removed index should be dropped")
+		t.Error(err)
+		panic(err)
+	}
+
+	indexes, err := engine.dialect.GetIndexes("sync_alter")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if _, ok := indexes["manual_old"]; !ok {
+		err = errors.New("index not created by xorm should not be dropped")
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Exec("CREATE TABLE sync_alter_null (id INTEGER PRIMARY KEY, name TEXT NOT NULL)")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	sqls, err = engine.SyncAlterDryRun(new(SyncAlterNull))
+	if _, ok := engine.dialect.(*sqlite3); ok {
+		if err == nil {
+			err = errors.New("sqlite3 should not support modifying column")
+			t.Error(err)
+			panic(err)
+		}
+	} else if err != nil || len(sqls) == 0 {
+		err = errors.New(fmt.Sprintf("nullable change should be planned: %v, %v", sqls, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	expected := &Column{Name: "name", SQLType: SQLType{Varchar, 255, 0}, Length: 255, Nullable: true}
+	actual := &Column{Name: "name", SQLType: SQLType{Varchar, 50, 0}, Length: 50, Nullable: true}
+	if diffs := diffColumn(&mysql{}, expected, actual); len(diffs) != 1 {
+		err = errors.New(fmt.Sprintf("varchar length change should be found: %v", diffs))
+		t.Error(err)
+		panic(err)
+	}
+	modifySqls, err := (&mysql{}).ModifyColumnSql("user", expected)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(modifySqls) != 1 || modifySqls[0] != "ALTER TABLE `user` MODIFY COLUMN `name` VARCHAR(255) NULL;" {
+		err = errors.New(fmt.Sprintf("mysql modify column sql is wrong: %v", modifySqls))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type DiffSchema struct {
+	Id    int64
+	Name  string `xorm:"index"`
+	Email string `xorm:"unique"`
+}
+
+type DiffSchemaMissing struct {
+	Id int64
+}
+
+func testDiffSchema(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(DiffSchema), new(DiffSchemaMissing), new(Uint64Id))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	err = engine.CreateTables(new(Uint64Id))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Exec("CREATE TABLE diff_schema (id INTEGER PRIMARY KEY, name TEXT NOT NULL, extra TEXT NULL)")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = engine.Exec("CREATE INDEX IDX_diff_schema_extra ON diff_schema (extra)")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	diffs, err := engine.DiffSchema(new(DiffSchema), new(DiffSchemaMissing), new(Uint64Id))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	for _, diff := range diffs {
+		fmt.Print(diff)
+	}
+	if len(diffs) != 2 {
+		err = errors.New(fmt.Sprintf("there should be 2 tables changed but %v", len(diffs)))
+		t.Error(err)
+		panic(err)
+	}
+
+	diff := diffs[0]
+	if diff.Name != "diff_schema" || diff.IsMissing ||
+		len(diff.MissingColumns) != 1 || diff.MissingColumns[0].Name != "email" ||
+		len(diff.ExtraColumns) != 1 || diff.ExtraColumns[0].Name != "extra" ||
+		len(diff.MismatchedColumns) != 1 || diff.MismatchedColumns[0].Expected.Name != "name" ||
+		len(diff.MissingIndexes) != 1 || diff.MissingIndexes[0].Name != "name" ||
+		len(diff.ExtraIndexes) != 1 || diff.ExtraIndexes[0].Name != "extra" ||
+		len(diff.MissingUniques) != 1 || diff.MissingUniques[0].Name != "email" ||
+		len(diff.ExtraUniques) != 0 {
+		err = errors.New(fmt.Sprintf("diff schema is wrong: %v", diff))
+		t.Error(err)
+		panic(err)
+	}
+
+	if !diffs[1].IsMissing || diffs[1].Name != "diff_schema_missing" {
+		err = errors.New(fmt.Sprintf("table should be missing: %v", diffs[1]))
+		t.Error(err)
+		panic(err)
+	}
+
+	exist, err := engine.IsTableExist(new(DiffSchemaMissing))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if exist {
+		err = errors.New("diff schema should not change database")
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type CondUser struct {
+	Id    int64
+	Name  string
+	Age   int
+	Email string
+}
+
+func testCond(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(CondUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	err = engine.CreateTables(new(CondUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Insert(&CondUser{Name: "lunny", Age: 30, Email: "lunny@xorm.io"},
+		&CondUser{Name: "xlw", Age: 20, Email: "xlw@xorm.io"},
+		&CondUser{Name: "lunny2", Age: 18}, &CondUser{Name: "tom", Age: 40})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = engine.Exec("update cond_user set email = NULL where name in (?, ?)", "lunny2", "tom")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	conds := []struct {
+		cond Cond
+		cnt  int64
+	}{
+		{Eq{"name": "lunny"}, 1},
+		{Eq{"name": "lunny", "age": 20}, 0},
+		{Neq{"name": "lunny"}, 3},
+		{Gt{"age": 20}, 2},
+		{Gte{"age": 20}, 3},
+		{Lt{"age": 20}, 1},
+		{Lte{"age": 20}, 2},
+		{Like{"name", "lunny%"}, 2},
+		{Between{"age", 18, 30}, 3},
+		{IsNull{"email"}, 2},
+		{Eq{"email": nil}, 2},
+		{NotNull{"email"}, 2},
+		{In("name", "lunny", "xlw"), 2},
+		{In("name"), 0},
+		{NotIn("name", "lunny", "xlw"), 2},
+		{NotIn("name"), 4},
+		{Or(Eq{"name": "lunny"}, And(Gt{"age": 18}, Lt{"age": 30})), 2},
+		{And(Or(Eq{"name": "lunny"}, Eq{"name": "tom"}), Gt{"age": 35}), 1},
+		{Not(Like{"name", "lunny%"}), 2},
+		{Or(Expr("age = ?", 40), Eq{"cond_user.name": "xlw"}), 2},
+	}
+	for _, c := range conds {
+		cnt, err := engine.Where(c.cond).Count(new(CondUser))
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+		if cnt != c.cnt {
+			sql, args := c.cond.ToSql(engine.Quote)
+			err = errors.New(fmt.Sprintf("%v %v should have %v records but %v", sql, args, c.cnt, cnt))
+			t.Error(err)
+			panic(err)
+		}
+	}
+
+	users := make([]CondUser, 0)
+	err = engine.Where("age > ?", 18).And(Like{"name", "lunny%"}).Or(In("name", "tom")).Find(&users)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(users) != 2 {
+		err = errors.New(fmt.Sprintf("string and cond mixed should find 2 records but %v", len(users)))
+		t.Error(err)
+		panic(err)
+	}
+
+	sql, args := Or(Eq{"a": 1, "b": 2}, Not(In("c", 3, 4))).ToSql(func(s string) string { return "[" + s + "]" })
+	if sql != "([a]=? AND [b]=?) OR (NOT ([c] IN (?, ?)))" || len(args) != 4 {
+		err = errors.New(fmt.Sprintf("cond sql is wrong: %v %v", sql, args))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type SubQueryOrder struct {
+	Id     int64
+	UserId int64
+	Total  int
+}
+
+func testSubQuery(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(SubQueryOrder))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	err = engine.CreateTables(new(SubQueryOrder))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	// the users are inserted by testCond
+	users := make([]CondUser, 0)
+	err = engine.Asc("id").Find(&users)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(users) != 4 {
+		err = errors.New(fmt.Sprintf("should have 4 users but %v", len(users)))
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Insert(&SubQueryOrder{UserId: users[0].Id, Total: 200},
+		&SubQueryOrder{UserId: users[0].Id, Total: 50},
+		&SubQueryOrder{UserId: users[1].Id, Total: 150},
+		&SubQueryOrder{UserId: users[2].Id, Total: 20})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	sub := engine.Table("sub_query_order").Cols("user_id").Where("total > ?", 100)
+	res := make([]CondUser, 0)
+	err = engine.In("id", sub).Find(&res)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(res) != 2 {
+		err = errors.New(fmt.Sprintf("in sub query should find 2 records but %v", len(res)))
+		t.Error(err)
+		panic(err)
+	}
+
+	// the args before and after the sub query should be kept in order
+	cnt, err := engine.Where("age > ? AND id IN ? AND name <> ?", 18, sub, "lunny").Count(new(CondUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if cnt != 1 {
+		err = errors.New(fmt.Sprintf("where sub query should have 1 record but %v", cnt))
+		t.Error(err)
+		panic(err)
+	}
+
+	// the "?" in a quoted string is not a placeholder
+	cnt, err = engine.Where("name <> 'who?' AND age > ? AND id IN ? AND name <> ?", 18, sub, "lunny").
+		Count(new(CondUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if cnt != 1 {
+		err = errors.New(fmt.Sprintf("where sub query with a quoted ? should have 1 record but %v", cnt))
+		t.Error(err)
+		panic(err)
+	}
+
+	conds := []struct {
+		cond Cond
+		cnt  int64
+	}{
+		{In("id", sub), 2},
+		{NotIn("id", sub), 2},
+		{Exists(engine.Table("sub_query_order").Where("sub_query_order.user_id = cond_user.id")), 3},
+		{NotExists(engine.Table("sub_query_order").Where("sub_query_order.user_id = cond_user.id")), 1},
+		{And(Gt{"age": 18}, Exists(engine.Table("sub_query_order").
+			Where("sub_query_order.user_id = cond_user.id AND total < ?", 100))), 1},
+	}
+	for _, c := range conds {
+		cnt, err := engine.Where(c.cond).Count(new(CondUser))
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+		if cnt != c.cnt {
+			sql, args := c.cond.ToSql(engine.Quote)
+			err = errors.New(fmt.Sprintf("%v %v should have %v records but %v", sql, args, c.cnt, cnt))
+			t.Error(err)
+			panic(err)
+		}
+	}
+
+	res = make([]CondUser, 0)
+	err = engine.Table(engine.Table("cond_user").Where("age > ?", 18), "u").
+		Where("age < ?", 40).Find(&res)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(res) != 2 {
+		err = errors.New(fmt.Sprintf("derived table should find 2 records but %v", len(res)))
+		t.Error(err)
+		panic(err)
+	}
+
+	cnt, err = engine.Table(engine.Table("cond_user").Where("age > ?", 18)).Count(new(CondUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if cnt != 3 {
+		err = errors.New(fmt.Sprintf("derived table should have 3 records but %v", cnt))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type JoinUser struct {
+	Id      int64
+	Name    string
+	GroupId int64
+}
+
+type JoinGroup struct {
+	Id   int64
+	Name string
+}
+
+type JoinUserGroup struct {
+	JoinUser  `xorm:"extends"`
+	JoinGroup `xorm:"extends"`
+}
+
+type JoinUserLeader struct {
+	JoinUser `xorm:"extends"`
+	Leader   JoinUser `xorm:"extends 'leader'"`
+}
+
+func testJoinExtends(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(JoinUser), new(JoinGroup))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	err = engine.CreateTables(new(JoinUser), new(JoinGroup))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	groups := []*JoinGroup{{Name: "dev"}, {Name: "test"}}
+	for _, group := range groups {
+		_, err = engine.Insert(group)
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+	}
+	_, err = engine.Insert(&JoinUser{Name: "lunny", GroupId: groups[1].Id},
+		&JoinUser{Name: "xlw", GroupId: groups[0].Id})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	ugs := make([]JoinUserGroup, 0)
+	err = engine.Table("join_user").Join("INNER", "join_group", "join_group.id = join_user.group_id").
+		Asc("join_user.id").Find(&ugs)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(ugs) != 2 {
+		err = errors.New(fmt.Sprintf("should find 2 records but %v", len(ugs)))
+		t.Error(err)
+		panic(err)
+	}
+	if ugs[0].JoinUser.Name != "lunny" || ugs[0].JoinGroup.Name != "test" ||
+		ugs[0].JoinGroup.Id != groups[1].Id || ugs[0].JoinUser.GroupId != groups[1].Id ||
+		ugs[1].JoinUser.Name != "xlw" || ugs[1].JoinGroup.Name != "dev" ||
+		ugs[1].JoinGroup.Id != groups[0].Id || ugs[0].JoinUser.Id == ugs[1].JoinUser.Id {
+		err = errors.New(fmt.Sprintf("join extends scanned wrong: %v", ugs))
+		t.Error(err)
+		panic(err)
+	}
+
+	var ug JoinUserGroup
+	has, err := engine.Table("join_user").Join("INNER", "join_group", "join_group.id = join_user.group_id").
+		Where("join_group.name = ?", "dev").Get(&ug)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if !has || ug.JoinUser.Name != "xlw" || ug.JoinGroup.Name != "dev" {
+		err = errors.New(fmt.Sprintf("join extends get wrong: %v %v", has, ug))
+		t.Error(err)
+		panic(err)
+	}
+
+	// the same table is joined twice by an alias, the group id is used as the
+	// leader's id here
+	uls := make([]JoinUserLeader, 0)
+	err = engine.Table("join_user").Join("INNER", "join_user leader", "leader.id = join_user.group_id").
+		Where("join_user.name = ?", "xlw").Find(&uls)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(uls) != 1 || uls[0].JoinUser.Name != "xlw" || uls[0].Leader.Name != "lunny" {
+		err = errors.New(fmt.Sprintf("join extends alias scanned wrong: %v", uls))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type PreloadUser struct {
+	Id      int64
+	Name    string
+	Profile *PreloadProfile `xorm:"has_one(user_id)"`
+	Orders  []PreloadOrder  `xorm:"has_many(user_id)"`
+	Roles   []*PreloadRole  `xorm:"many_to_many(preload_user_role,user_id,role_id)"`
+}
+
+type PreloadProfile struct {
+	Id     int64
+	UserId int64
+	Bio    string
+}
+
+type PreloadOrder struct {
+	Id     int64
+	UserId int64
+	Total  int
+	User   PreloadUser    `xorm:"belongs_to"`
+	Items  []*PreloadItem `xorm:"has_many(order_id)"`
+}
+
+type PreloadItem struct {
+	Id      int64
+	OrderId int64
+	Name    string
+}
+
+type PreloadRole struct {
+	Id   int64
+	Name string
+}
+
+type PreloadUserRole struct {
+	Id     int64
+	UserId int64
+	RoleId int64
+}
+
+// count the executed sqls
+type countFilter struct {
+	count int
+}
+
+func (filter *countFilter) Do(sql string, session *Session) string {
+	filter.count++
+	return sql
+}
+
+func testPreload(engine *Engine, t *testing.T) {
+	beans := []interface{}{new(PreloadUser), new(PreloadProfile), new(PreloadOrder),
+		new(PreloadItem), new(PreloadRole), new(PreloadUserRole)}
+	err := engine.DropTables(beans...)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(beans...)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	users := []*PreloadUser{{Name: "lunny"}, {Name: "xlw"}, {Name: "tom"}}
+	roles := []*PreloadRole{{Name: "admin"}, {Name: "dev"}}
+	for _, bean := range []interface{}{users[0], users[1], users[2], roles[0], roles[1]} {
+		_, err = engine.Insert(bean)
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+	}
+	orders := []*PreloadOrder{{UserId: users[0].Id, Total: 10}, {UserId: users[0].Id, Total: 20},
+		{UserId: users[1].Id, Total: 30}}
+	for _, order := range orders {
+		_, err = engine.Insert(order)
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+	}
+	_, err = engine.Insert(&PreloadProfile{UserId: users[1].Id, Bio: "xlw's bio"},
+		&PreloadItem{OrderId: orders[0].Id, Name: "book"}, &PreloadItem{OrderId: orders[0].Id, Name: "pen"},
+		&PreloadItem{OrderId: orders[2].Id, Name: "cup"},
+		&PreloadUserRole{UserId: users[0].Id, RoleId: roles[0].Id},
+		&PreloadUserRole{UserId: users[0].Id, RoleId: roles[1].Id},
+		&PreloadUserRole{UserId: users[2].Id, RoleId: roles[1].Id})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	filter := &countFilter{}
+	engine.Filters = append(engine.Filters, filter)
+	res := make([]PreloadUser, 0)
+	err = engine.Preload("Profile", "Orders.Items", "Roles").Asc("id").Find(&res)
+	engine.Filters = engine.Filters[:len(engine.Filters)-1]
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	// users, profiles, orders, items, user roles and roles
+	if engine.Cacher == nil && filter.count != 6 {
+		err = errors.New(fmt.Sprintf("preload should execute 6 sqls but %v", filter.count))
+		t.Error(err)
+		panic(err)
+	}
+	if len(res) != 3 {
+		err = errors.New(fmt.Sprintf("should find 3 users but %v", len(res)))
+		t.Error(err)
+		panic(err)
+	}
+	if res[0].Profile != nil || res[1].Profile == nil || res[1].Profile.Bio != "xlw's bio" ||
+		len(res[0].Orders) != 2 || len(res[1].Orders) != 1 || len(res[2].Orders) != 0 ||
+		len(res[0].Orders[0].Items)+len(res[0].Orders[1].Items) != 2 || len(res[1].Orders[0].Items) != 1 ||
+		res[1].Orders[0].Items[0].Name != "cup" ||
+		len(res[0].Roles) != 2 || len(res[1].Roles) != 0 || len(res[2].Roles) != 1 || res[2].Roles[0].Name != "dev" {
+		err = errors.New(fmt.Sprintf("preload is wrong: %v", res))
+		t.Error(err)
+		panic(err)
+	}
+
+	order := new(PreloadOrder)
+	has, err := engine.Id(orders[2].Id).Preload("User.Roles", "Items").Get(order)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if !has || order.User.Name != "xlw" || len(order.User.Roles) != 0 || len(order.Items) != 1 {
+		err = errors.New(fmt.Sprintf("preload get is wrong: %v %v", has, order))
+		t.Error(err)
+		panic(err)
+	}
+
+	err = engine.Preload("Unknown").Find(&res)
+	if err == nil {
+		err = errors.New("preload an unknown relation should fail")
+		t.Error(err)
+		panic(err)
+	}
+
+	// the keys are more than the arguments a statement could have
+	size := engine.dialect.MaxInsertArgs() + 10
+	others := make([]PreloadUser, size)
+	for i := range others {
+		others[i] = PreloadUser{Name: fmt.Sprintf("other%v", i)}
+	}
+	_, err = engine.Insert(&others)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	last := new(PreloadUser)
+	has, err = engine.Where("name = ?", others[size-1].Name).Get(last)
+	if err != nil || !has {
+		err = errors.New(fmt.Sprintf("should get the last user but %v %v", has, err))
+		t.Error(err)
+		panic(err)
+	}
+	_, err = engine.Insert(&PreloadOrder{UserId: last.Id, Total: 40},
+		&PreloadUserRole{UserId: last.Id, RoleId: roles[0].Id})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	res = make([]PreloadUser, 0)
+	err = engine.Preload("Orders", "Roles").Asc("id").Find(&res)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(res) != size+3 {
+		err = errors.New(fmt.Sprintf("should find %v users but %v", size+3, len(res)))
+		t.Error(err)
+		panic(err)
+	}
+	found := res[len(res)-1]
+	if found.Id != last.Id || len(found.Orders) != 1 || found.Orders[0].Total != 40 ||
+		len(found.Roles) != 1 || found.Roles[0].Name != "admin" || len(res[0].Orders) != 2 || len(res[0].Roles) != 2 {
+		err = errors.New(fmt.Sprintf("preload by chunks is wrong: %v %v", res[0], found))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type SavepointUser struct {
+	Id   int64
+	Name string
+}
+
+// a library function which has its own transaction
+func insertInTransaction(session *Session, name string, commit bool) error {
+	err := session.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = session.Insert(&SavepointUser{Name: name})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+	if !commit {
+		return session.Rollback()
+	}
+	return session.Commit()
+}
+
+func testSavepoint(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(SavepointUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(SavepointUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	session := engine.NewSession()
+	defer session.Close()
+
+	err = session.Begin()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = session.Insert(&SavepointUser{Name: "outer"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = insertInTransaction(session, "rollbacked", false)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	// two levels of nested transactions
+	err = session.Begin()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = insertInTransaction(session, "committed", true)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = session.Commit()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = session.Commit()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	users := make([]SavepointUser, 0)
+	err = engine.Asc("id").Find(&users)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(users) != 2 || users[0].Name != "outer" || users[1].Name != "committed" {
+		err = errors.New(fmt.Sprintf("nested transactions are wrong: %v", users))
+		t.Error(err)
+		panic(err)
+	}
+
+	// the nested commit is rollbacked by the outer transaction, and the
+	// session could begin a new transaction after commit
+	err = session.Begin()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = insertInTransaction(session, "inner", true)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = session.Rollback()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	cnt, err := engine.Count(new(SavepointUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if cnt != 2 {
+		err = errors.New(fmt.Sprintf("outer rollback should rollback nested commit, but has %v records", cnt))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type TxUser struct {
+	Id   int64
+	Name string
+}
+
+// an error which every dialect treats as retryable
+type retryableError struct {
+	Number uint16
+	Code   string
+}
+
+func (err *retryableError) Error() string {
+	return "database is locked"
+}
+
+func testTxHelper(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(TxUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(TxUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	insertFunc := func(name string, err error) func(*Session) error {
+		return func(session *Session) error {
+			_, e := session.Insert(&TxUser{Name: name})
+			if e != nil {
+				return e
+			}
+			return err
+		}
+	}
+
+	err = engine.Transaction(insertFunc("committed", nil))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	fail := errors.New("failed")
+	err = engine.Transaction(insertFunc("rollbacked", fail))
+	if err != fail {
+		err = errors.New(fmt.Sprintf("transaction should return the error but %v", err))
+		t.Error(err)
+		panic(err)
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "panicked" {
+				err = errors.New(fmt.Sprintf("transaction should re-panic but %v", p))
+				t.Error(err)
+				panic(err)
+			}
+		}()
+		engine.Transaction(func(session *Session) error {
+			insertFunc("panicked", nil)(session)
+			panic("panicked")
+		})
+	}()
+
+	// a nested transaction is rollbacked but the outer is committed
+	err = engine.Transaction(func(session *Session) error {
+		err := session.Transaction(insertFunc("nested rollbacked", fail))
+		if err != fail {
+			return errors.New(fmt.Sprintf("nested transaction should return the error but %v", err))
+		}
+		return session.Transaction(insertFunc("nested committed", nil))
+	})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	oldRetries := engine.TxRetries
+	defer func() {
+		engine.TxRetries = oldRetries
+	}()
+	engine.TxRetries = 2
+	var times int
+	err = engine.Transaction(func(session *Session) error {
+		times++
+		if times < 3 {
+			return insertFunc("retried", &retryableError{1213, "40001"})(session)
+		}
+		return insertFunc("retried", nil)(session)
+	})
+	if err != nil || times != 3 {
+		err = errors.New(fmt.Sprintf("transaction should be retried twice but %v %v", times, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	engine.TxRetries = 1
+	times = 0
+	err = engine.Transaction(func(session *Session) error {
+		times++
+		return &retryableError{1213, "40001"}
+	})
+	if err == nil || times != 2 {
+		err = errors.New(fmt.Sprintf("transaction should fail after 1 retry but %v %v", times, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	times = 0
+	err = engine.Transaction(func(session *Session) error {
+		times++
+		return fail
+	})
+	if err != fail || times != 1 {
+		err = errors.New(fmt.Sprintf("transaction should not retry other errors but %v %v", times, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	users := make([]TxUser, 0)
+	err = engine.Asc("id").Find(&users)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(users) != 3 || users[0].Name != "committed" || users[1].Name != "nested committed" ||
+		users[2].Name != "retried" {
+		err = errors.New(fmt.Sprintf("transaction helper is wrong: %v", users))
+		t.Error(err)
+		panic(err)
+	}
+
+	// the session is not in a transaction after the transaction helper
+	session := engine.NewSession()
+	defer session.Close()
+	err = session.Transaction(insertFunc("session committed", nil))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = session.Insert(&TxUser{Name: "after transaction"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	cnt, err := engine.Count(new(TxUser))
+	if err != nil || cnt != 5 {
+		err = errors.New(fmt.Sprintf("should have 5 users but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+func testIsolation(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(TxUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(TxUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	session := engine.NewSession()
+	defer session.Close()
+
+	err = session.BeginWith(&sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = session.Insert(&TxUser{Name: "readonly"})
+	if err == nil {
+		err = errors.New("insert in a read only transaction should fail")
+		t.Error(err)
+		panic(err)
+	}
+	err = session.Rollback()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	// the connection should be writable after the read only transaction
+	for i := 0; i < 3; i++ {
+		_, err = engine.Insert(&TxUser{Name: fmt.Sprintf("user%v", i)})
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+	}
+
+	err = session.BeginWith(&sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = session.Insert(&TxUser{Name: "serializable"})
+	if err != nil {
+		session.Rollback()
+		t.Error(err)
+		panic(err)
+	}
+	// the query in a transaction should see the uncommitted insert
+	cnt, err := session.Count(new(TxUser))
+	if err != nil || cnt != 4 {
+		session.Rollback()
+		err = errors.New(fmt.Sprintf("should count 4 records in transaction but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+	err = session.BeginWith(&sql.TxOptions{ReadOnly: true})
+	if err == nil {
+		err = errors.New("nested transaction should not have options")
+		t.Error(err)
+		panic(err)
+	}
+	err = session.Commit()
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	err = session.BeginWith(&sql.TxOptions{Isolation: sql.LevelLinearizable})
+	if err == nil {
+		err = errors.New("unsupported isolation level should fail")
+		t.Error(err)
+		panic(err)
+	}
+
+	users := make([]TxUser, 0)
+	err = engine.TransactionWith(&sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true},
+		func(session *Session) error {
+			return session.Find(&users)
+		})
+	if err != nil || len(users) != 4 {
+		err = errors.New(fmt.Sprintf("should find 4 records in read only transaction but %v %v", len(users), err))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+// count the dbs retrieved by a slave, and fail the retrieving when it's down
+type slavePool struct {
+	IConnectPool
+	retrieved int
+	down      bool
+}
+
+func (pool *slavePool) RetrieveDB(engine *Engine) (*sql.DB, error) {
+	if pool.down {
+		return nil, errors.New("slave is down")
+	}
+	pool.retrieved++
+	return pool.IConnectPool.RetrieveDB(engine)
+}
+
+func checkSlaves(t *testing.T, pools []*slavePool, retrieved ...int) {
+	for i, pool := range pools {
+		if pool.retrieved != retrieved[i] {
+			err := errors.New(fmt.Sprintf("slave %v should be retrieved %v times but %v",
+				i, retrieved[i], pool.retrieved))
+			t.Error(err)
+			panic(err)
+		}
+		pool.retrieved = 0
+	}
+}
+
+func testEngineGroup(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(TxUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(TxUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = engine.Insert(&TxUser{Name: "user1"}, &TxUser{Name: "user2"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	// the slaves are the same database as the master, the reads are checked
+	// by the retrieved dbs of the slaves
+	slaves := make([]*Engine, 2)
+	pools := make([]*slavePool, 2)
+	for i := range slaves {
+		slaves[i], err = NewEngine(engine.DriverName, engine.DataSourceName)
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+		defer slaves[i].Close()
+		pools[i] = &slavePool{IConnectPool: NewSysConnectPool()}
+		err = slaves[i].SetPool(pools[i])
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+	}
+	group := NewEngineGroup(engine, slaves)
+	defer func() {
+		engine.group = nil
+	}()
+
+	// the cacher may read the beans by other sessions, so don't use it
+	users := make([]TxUser, 0)
+	err = group.NoCache().Find(&users)
+	if err != nil || len(users) != 2 {
+		err = errors.New(fmt.Sprintf("should find 2 users but %v %v", len(users), err))
+		t.Error(err)
+		panic(err)
+	}
+	_, err = group.NoCache().Get(&TxUser{Name: "user1"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	cnt, err := group.Count(new(TxUser))
+	if err != nil || cnt != 2 {
+		err = errors.New(fmt.Sprintf("should count 2 users but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+	_, err = group.Query("select * from " + engine.Quote("tx_user"))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	checkSlaves(t, pools, 2, 2)
+
+	// writes, transactions and forced master reads are executed by the master
+	_, err = group.Insert(&TxUser{Name: "user3"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = group.Transaction(func(session *Session) error {
+		_, err := session.Count(new(TxUser))
+		return err
+	})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	cnt, err = group.ForceMaster().Count(new(TxUser))
+	if err != nil || cnt != 3 {
+		err = errors.New(fmt.Sprintf("should count 3 users but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+	checkSlaves(t, pools, 0, 0)
+
+	group.SetPolicy(WeightRandomPolicy(0, 1))
+	for i := 0; i < 3; i++ {
+		_, err = group.Count(new(TxUser))
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+	}
+	checkSlaves(t, pools, 0, 3)
+
+	// the down slave should not be read until it's up again
+	group.SetPolicy(RoundRobinPolicy())
+	pools[1].down = true
+	group.CheckHealth()
+	checkSlaves(t, pools, 1, 0)
+	for i := 0; i < 2; i++ {
+		_, err = group.Count(new(TxUser))
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+	}
+	checkSlaves(t, pools, 2, 0)
+
+	pools[0].down = true
+	group.CheckHealth()
+	cnt, err = group.Count(new(TxUser))
+	if err != nil || cnt != 3 {
+		err = errors.New(fmt.Sprintf("should count 3 users from master but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+	checkSlaves(t, pools, 0, 0)
+
+	pools[1].down = false
+	group.CheckHealth()
+	checkSlaves(t, pools, 0, 1)
+	_, err = group.Count(new(TxUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	checkSlaves(t, pools, 0, 1)
+
+	// the statements of the slaves are cached and counted too
+	pools[0].down = false
+	group.CheckHealth()
+	group.SetStmtCache(2)
+	defer group.SetStmtCache(0)
+	stats := group.StmtCacheStats()
+	for i := 0; i < 4; i++ {
+		_, err = group.Count(new(TxUser))
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+	}
+	now := group.StmtCacheStats()
+	for i, slave := range slaves {
+		if slaveStats := slave.StmtCacheStats(); slaveStats.Hits != 1 || slaveStats.Misses != 1 {
+			err = errors.New(fmt.Sprintf("slave %v should hit the cache once but %v", i, slaveStats))
+			t.Error(err)
+			panic(err)
+		}
+	}
+	if now.Hits-stats.Hits != 2 || now.Misses-stats.Misses != 2 {
+		err = errors.New(fmt.Sprintf("the group should hit the cache twice but %v %v", stats, now))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type ShardOrder struct {
+	Id     int64
+	UserId int64
+	Amount int
+}
+
+func testShard(engine *Engine, t *testing.T) {
+	engine.Shard(new(ShardOrder), HashShard("user_id", 4))
+	names := engine.ShardTables(new(ShardOrder))
+	if strings.Join(names, ",") != "shard_order_0,shard_order_1,shard_order_2,shard_order_3" {
+		err := errors.New(fmt.Sprintf("wrong shard tables %v", names))
+		t.Error(err)
+		panic(err)
+	}
+	for _, name := range names {
+		err := engine.DropTables(name)
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+		err = engine.Table(name).CreateTable(new(ShardOrder))
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+	}
+
+	orders := make([]ShardOrder, 0)
+	for i := 0; i < 8; i++ {
+		orders = append(orders, ShardOrder{UserId: int64(i%4 + 1), Amount: i + 1})
+	}
+	_, err := engine.Insert(&orders)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = engine.Insert(&ShardOrder{UserId: 5, Amount: 9})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	cnt, err := engine.Table("shard_order_1").Count(new(ShardOrder))
+	if err != nil || cnt != 3 {
+		err = errors.New(fmt.Sprintf("shard_order_1 should have 3 orders but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	orders = make([]ShardOrder, 0)
+	err = engine.Find(&orders, &ShardOrder{UserId: 2})
+	if err != nil || len(orders) != 2 {
+		err = errors.New(fmt.Sprintf("should find 2 orders of user 2 but %v %v", len(orders), err))
+		t.Error(err)
+		panic(err)
+	}
+	order := ShardOrder{UserId: 3}
+	has, err := engine.Get(&order)
+	if err != nil || !has || order.Amount%4 != 3 {
+		err = errors.New(fmt.Sprintf("should get an order of user 3 but %v %v", order, err))
+		t.Error(err)
+		panic(err)
+	}
+	// the conditions in Where can't route
+	cnt, err = engine.ShardKey(1).Where("user_id = ?", 1).Count(new(ShardOrder))
+	if err != nil || cnt != 2 {
+		err = errors.New(fmt.Sprintf("should count 2 orders of user 1 but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	// without the shard key, all the shards are queried and merged
+	orders = make([]ShardOrder, 0)
+	err = engine.Desc("amount").Limit(3, 1).Find(&orders)
+	if err != nil || len(orders) != 3 || orders[0].Amount != 8 || orders[2].Amount != 6 {
+		err = errors.New(fmt.Sprintf("should find the orders 8, 7, 6 but %v %v", orders, err))
+		t.Error(err)
+		panic(err)
+	}
+	order = ShardOrder{}
+	has, err = engine.Asc("amount").Get(&order)
+	if err != nil || !has || order.Amount != 1 {
+		err = errors.New(fmt.Sprintf("should get the order 1 but %v %v", order, err))
+		t.Error(err)
+		panic(err)
+	}
+	cnt, err = engine.Count(new(ShardOrder))
+	if err != nil || cnt != 9 {
+		err = errors.New(fmt.Sprintf("should count 9 orders but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	affected, err := engine.Update(&ShardOrder{Amount: 100}, &ShardOrder{UserId: 2})
+	if err != nil || affected != 2 {
+		err = errors.New(fmt.Sprintf("should update 2 orders of user 2 but %v %v", affected, err))
+		t.Error(err)
+		panic(err)
+	}
+	affected, err = engine.Where("amount = ?", 100).Update(&ShardOrder{Amount: 200})
+	if err != nil || affected != 2 {
+		err = errors.New(fmt.Sprintf("should update 2 orders but %v %v", affected, err))
+		t.Error(err)
+		panic(err)
+	}
+	affected, err = engine.Delete(&ShardOrder{UserId: 5})
+	if err != nil || affected != 1 {
+		err = errors.New(fmt.Sprintf("should delete 1 order of user 5 but %v %v", affected, err))
+		t.Error(err)
+		panic(err)
+	}
+	affected, err = engine.Where("amount = ?", 200).Delete(new(ShardOrder))
+	if err != nil || affected != 2 {
+		err = errors.New(fmt.Sprintf("should delete 2 orders but %v %v", affected, err))
+		t.Error(err)
+		panic(err)
+	}
+	cnt, err = engine.Count(new(ShardOrder))
+	if err != nil || cnt != 6 {
+		err = errors.New(fmt.Sprintf("should count 6 orders but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	rule := RangeShard("id", 100, 200)
+	for key, suffix := range map[int]string{5: "0", 100: "1", 150: "1", 250: "2"} {
+		s, err := rule.Suffix(key)
+		if err != nil || s != suffix {
+			err = errors.New(fmt.Sprintf("range shard of %v should be %v but %v %v", key, suffix, s, err))
+			t.Error(err)
+			panic(err)
+		}
+	}
+	rule = MonthShard("created", time.Date(2014, 1, 10, 0, 0, 0, 0, time.UTC),
+		time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC))
+	s, err := rule.Suffix(time.Date(2014, 2, 28, 0, 0, 0, 0, time.UTC))
+	if err != nil || s != "201402" || len(rule.Suffixes()) != 3 {
+		err = errors.New(fmt.Sprintf("month shard should be 201402 of 3 months but %v %v", s, err))
+		t.Error(err)
+		panic(err)
+	}
+	_, err = rule.Suffix(time.Date(2014, 4, 1, 0, 0, 0, 0, time.UTC))
+	if err == nil {
+		err = errors.New("month out of the shards should fail")
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type HookUser struct {
+	Id    int64
+	Name  string
+	Total int64 `xorm:"-"`
+}
+
+var hookEvents []string
+
+func (user *HookUser) BeforeInsert() {
+	user.Name += "!"
+	hookEvents = append(hookEvents, "BeforeInsert")
+}
+
+func (user *HookUser) AfterInsert() {
+	hookEvents = append(hookEvents, fmt.Sprintf("AfterInsert %v", user.Id > 0))
+}
+
+func (user *HookUser) BeforeUpdate() {
+	hookEvents = append(hookEvents, "BeforeUpdate")
+}
+
+func (user *HookUser) AfterUpdate() {
+	hookEvents = append(hookEvents, "AfterUpdate")
+}
+
+func (user *HookUser) BeforeDelete() {
+	hookEvents = append(hookEvents, "BeforeDelete")
+}
+
+func (user *HookUser) AfterDelete() {
+	hookEvents = append(hookEvents, "AfterDelete")
+}
+
+func (user *HookUser) AfterLoad(session *Session) {
+	total, err := session.Count(new(HookUser))
+	if err == nil {
+		user.Total = total
+	}
+}
+
+func checkHookEvents(t *testing.T, events ...string) {
+	if strings.Join(hookEvents, ",") != strings.Join(events, ",") {
+		err := errors.New(fmt.Sprintf("hook events should be %v but %v", events, hookEvents))
+		t.Error(err)
+		panic(err)
+	}
+	hookEvents = nil
+}
+
+func testHooks(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(HookUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(HookUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	hookEvents = nil
+	user := HookUser{Name: "user1"}
+	_, err = engine.Insert(&user)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	checkHookEvents(t, "BeforeInsert", "AfterInsert true")
+	_, err = engine.Insert([]HookUser{{Name: "user2"}, {Name: "user3"}})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(hookEvents) != 4 {
+		err = errors.New(fmt.Sprintf("should have 4 insert hook events but %v", hookEvents))
+		t.Error(err)
+		panic(err)
+	}
+	hookEvents = nil
+
+	// the bean changed by BeforeInsert is inserted
+	loaded := HookUser{Id: user.Id}
+	has, err := engine.Get(&loaded)
+	if err != nil || !has || loaded.Name != "user1!" || loaded.Total != 3 {
+		err = errors.New(fmt.Sprintf("should get user1! loaded with total 3 but %v %v", loaded, err))
+		t.Error(err)
+		panic(err)
+	}
+	users := make([]HookUser, 0)
+	err = engine.Find(&users)
+	if err != nil || len(users) != 3 || users[2].Total != 3 {
+		err = errors.New(fmt.Sprintf("should find 3 loaded users but %v %v", users, err))
+		t.Error(err)
+		panic(err)
+	}
+	userMap := make(map[int64]HookUser)
+	err = engine.Find(&userMap)
+	if err != nil || len(userMap) != 3 || userMap[user.Id].Total != 3 {
+		err = errors.New(fmt.Sprintf("should find 3 loaded users but %v %v", userMap, err))
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.Iterate(new(HookUser), func(i int, bean interface{}) error {
+		if bean.(*HookUser).Total != 3 {
+			return errors.New(fmt.Sprintf("should iterate loaded users but %v", bean))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Update(&HookUser{Name: "user1"}, &HookUser{Id: user.Id})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	checkHookEvents(t, "BeforeUpdate", "AfterUpdate")
+
+	// the closures are called before the processors
+	affected, err := engine.Before(func(bean interface{}) {
+		hookEvents = append(hookEvents, "before")
+	}).After(func(bean interface{}) {
+		hookEvents = append(hookEvents, "after")
+	}).Delete(&HookUser{Id: user.Id})
+	if err != nil || affected != 1 {
+		err = errors.New(fmt.Sprintf("should delete 1 user but %v %v", affected, err))
+		t.Error(err)
+		panic(err)
+	}
+	checkHookEvents(t, "before", "BeforeDelete", "after", "AfterDelete")
+
+	// the after hooks are not called when failed
+	_, err = engine.Delete(new(HookUser))
+	if err == nil {
+		err = errors.New("delete without conditions should fail")
+		t.Error(err)
+		panic(err)
+	}
+	checkHookEvents(t, "BeforeDelete")
+}
+
+type SoftUser struct {
+	Id        int64
+	Name      string
+	DeletedAt time.Time `xorm:"deleted"`
+}
+
+func testSoftDelete(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(SoftUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(SoftUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	users := []SoftUser{{Name: "user1"}, {Name: "user2"}, {Name: "user3"}}
+	_, err = engine.Insert(&users)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	user := SoftUser{Name: "user1"}
+	has, err := engine.Get(&user)
+	if err != nil || !has {
+		err = errors.New(fmt.Sprintf("should get user1 but %v %v", has, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	affected, err := engine.Delete(&SoftUser{Id: user.Id})
+	if err != nil || affected != 1 {
+		err = errors.New(fmt.Sprintf("should soft delete 1 user but %v %v", affected, err))
+		t.Error(err)
+		panic(err)
+	}
+	// the deleted record could not be deleted again
+	affected, err = engine.Delete(&SoftUser{Id: user.Id})
+	if err != nil || affected != 0 {
+		err = errors.New(fmt.Sprintf("should soft delete no user but %v %v", affected, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	has, err = engine.Get(&SoftUser{Id: user.Id})
+	if err != nil || has {
+		err = errors.New(fmt.Sprintf("should not get the deleted user but %v %v", has, err))
+		t.Error(err)
+		panic(err)
+	}
+	deleted := SoftUser{Id: user.Id}
+	has, err = engine.Unscoped().Get(&deleted)
+	if err != nil || !has || deleted.DeletedAt.IsZero() {
+		err = errors.New(fmt.Sprintf("should get the deleted user unscoped but %v %v", deleted, err))
+		t.Error(err)
+		panic(err)
+	}
+	users = make([]SoftUser, 0)
+	err = engine.Find(&users)
+	if err != nil || len(users) != 2 {
+		err = errors.New(fmt.Sprintf("should find 2 users but %v %v", len(users), err))
+		t.Error(err)
+		panic(err)
+	}
+	cnt, err := engine.Where("id = ? OR name = ?", user.Id, "user2").Count(new(SoftUser))
+	if err != nil || cnt != 1 {
+		err = errors.New(fmt.Sprintf("should count 1 user but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+	cnt, err = engine.Unscoped().Count(new(SoftUser))
+	if err != nil || cnt != 3 {
+		err = errors.New(fmt.Sprintf("should count 3 users unscoped but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+	cnt = 0
+	err = engine.Iterate(new(SoftUser), func(i int, bean interface{}) error {
+		cnt++
+		return nil
+	})
+	if err != nil || cnt != 2 {
+		err = errors.New(fmt.Sprintf("should iterate 2 users but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	affected, err = engine.Unscoped().Delete(&SoftUser{Id: user.Id})
+	if err != nil || affected != 1 {
+		err = errors.New(fmt.Sprintf("should purge 1 user but %v %v", affected, err))
+		t.Error(err)
+		panic(err)
+	}
+	cnt, err = engine.Unscoped().Count(new(SoftUser))
+	if err != nil || cnt != 2 {
+		err = errors.New(fmt.Sprintf("should count 2 users unscoped but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	testSoftDeletePtr(engine, t)
+}
+
+type SoftPtrUser struct {
+	Id        int64
+	Name      string
+	DeletedAt *time.Time `xorm:"deleted"`
+}
+
+// the loaded soft deleted record is purged by the conditions of all its
+// fields, the deleted time is compared in the database's time zone
+func testSoftDeletePtr(engine *Engine, t *testing.T) {
+	databaseTZ := engine.DatabaseTZ
+	engine.DatabaseTZ = time.FixedZone("db", -5*3600)
+	defer func() {
+		engine.DatabaseTZ = databaseTZ
+	}()
+
+	err := engine.DropTables(new(SoftPtrUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(SoftPtrUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	user := SoftPtrUser{Name: "user1"}
+	_, err = engine.Insert(&user)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	affected, err := engine.Id(user.Id).Delete(new(SoftPtrUser))
+	if err != nil || affected != 1 {
+		err = errors.New(fmt.Sprintf("should soft delete 1 user but %v %v", affected, err))
+		t.Error(err)
+		panic(err)
+	}
+	loaded := SoftPtrUser{}
+	has, err := engine.Unscoped().Id(user.Id).Get(&loaded)
+	if err != nil || !has || loaded.DeletedAt == nil {
+		err = errors.New(fmt.Sprintf("should get the deleted user unscoped but %v %v %v", loaded, has, err))
+		t.Error(err)
+		panic(err)
+	}
+	affected, err = engine.Unscoped().Delete(&loaded)
+	if err != nil || affected != 1 {
+		err = errors.New(fmt.Sprintf("should purge the loaded user but %v %v", affected, err))
+		t.Error(err)
+		panic(err)
+	}
+	cnt, err := engine.Unscoped().Count(new(SoftPtrUser))
+	if err != nil || cnt != 0 {
+		err = errors.New(fmt.Sprintf("should count no user unscoped but %v %v", cnt, err))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type LockUser struct {
+	Id   int64
+	Name string
+	Ver  int `xorm:"version"`
+}
+
+func testOptimisticLock(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(LockUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(LockUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	user := LockUser{Name: "user1"}
+	_, err = engine.Insert(&user)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if user.Ver != 1 {
+		err = errors.New(fmt.Sprintf("inserted version should be 1 but %v", user.Ver))
+		t.Error(err)
+		panic(err)
+	}
+
+	var user1, user2 LockUser
+	_, err = engine.Id(user.Id).Get(&user1)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = engine.Id(user.Id).Get(&user2)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	user1.Name = "user1 updated"
+	affected, err := engine.Id(user.Id).Update(&user1)
+	if err != nil || affected != 1 || user1.Ver != 2 {
+		err = errors.New(fmt.Sprintf("should update version to 2 but %v %v %v", user1.Ver, affected, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	// the version of user2 is stale
+	user2.Name = "user2 updated"
+	_, err = engine.Id(user.Id).Update(&user2)
+	if err != ErrOptimisticLock || user2.Ver != 1 {
+		err = errors.New(fmt.Sprintf("should fail by the stale version but %v %v", user2.Ver, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	loaded := LockUser{}
+	_, err = engine.Id(user.Id).Get(&loaded)
+	if err != nil || loaded.Name != "user1 updated" || loaded.Ver != 2 {
+		err = errors.New(fmt.Sprintf("should get user1 updated of version 2 but %v %v", loaded, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	user1.Name = "user1 updated again"
+	_, err = engine.Id(user.Id).Update(&user1)
+	if err != nil || user1.Ver != 3 {
+		err = errors.New(fmt.Sprintf("should update version to 3 but %v %v", user1.Ver, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	// the version of a struct which is not loaded is zero
+	affected, err = engine.Id(user.Id).Update(&LockUser{Name: "no version"})
+	if err != ErrZeroVersion || affected != 0 {
+		err = errors.New(fmt.Sprintf("should fail by the zero version but %v %v", affected, err))
+		t.Error(err)
+		panic(err)
+	}
+	loaded = LockUser{}
+	_, err = engine.Id(user.Id).Get(&loaded)
+	if err != nil || loaded.Name != "user1 updated again" || loaded.Ver != 3 {
+		err = errors.New(fmt.Sprintf("should not update by the zero version but %v %v", loaded, err))
+		t.Error(err)
+		panic(err)
+	}
+}
+
 func testAll(engine *Engine, t *testing.T) {
 	fmt.Println("-------------- directCreateTable --------------")
 	directCreateTable(engine, t)
@@ -1650,6 +4008,861 @@ func testAll(engine *Engine, t *testing.T) {
 	having(engine, t)
 }
 
+type UpsertUser struct {
+	Id      int64
+	Name    string `xorm:"unique"`
+	Age     int
+	Created time.Time `xorm:"created"`
+	Ver     int       `xorm:"version"`
+}
+
+func testUpsert(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(UpsertUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(UpsertUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateUniques(new(UpsertUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Upsert(&UpsertUser{Name: "user1", Age: 10}, "name")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	user := UpsertUser{Name: "user1"}
+	has, err := engine.Get(&user)
+	if err != nil || !has || user.Age != 10 || user.Ver != 1 {
+		err = errors.New(fmt.Sprintf("should insert user1 of age 10 but %v %v %v", user, has, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	// update by the unique name
+	_, err = engine.Upsert(&UpsertUser{Name: "user1", Age: 20}, "name")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	// update by the primary key, the age is omitted
+	_, err = engine.Omit("age").Upsert(&UpsertUser{Id: user.Id, Name: "user2", Age: 30})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	users := make([]UpsertUser, 0)
+	err = engine.Find(&users)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(users) != 1 || users[0].Id != user.Id || users[0].Name != "user2" ||
+		users[0].Age != 20 || users[0].Ver != 3 || !users[0].Created.Equal(user.Created) {
+		err = errors.New(fmt.Sprintf("should update user1 to user2 of age 20 and version 3 but %v, inserted %v", users, user))
+		t.Error(err)
+		panic(err)
+	}
+
+	loaded := UpsertUser{}
+	has, err = engine.Id(user.Id).Get(&loaded)
+	if err != nil || !has || loaded.Name != "user2" {
+		err = errors.New(fmt.Sprintf("should get the upserted user2 but %v %v %v", loaded, has, err))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type ChunkUser struct {
+	Id   int64
+	Name string `xorm:"unique"`
+	Age  int
+}
+
+func testChunkInsert(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(ChunkUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(ChunkUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateUniques(new(ChunkUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	// more arguments than a statement of any database could have
+	size := engine.dialect.MaxInsertArgs()/3 + 100
+	users := make([]ChunkUser, size)
+	for i := range users {
+		users[i] = ChunkUser{Name: fmt.Sprintf("user%v", i), Age: i}
+	}
+	affected, err := engine.ChunksInTx().Insert(&users)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	total, err := engine.Count(new(ChunkUser))
+	if err != nil || affected != int64(size) || total != int64(size) {
+		err = errors.New(fmt.Sprintf("should insert %v users but %v %v %v", size, affected, total, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	if engine.dialect.SupportReturning() || engine.dialect.ConsecutiveInsertIds() {
+		for _, i := range []int{0, size / 2, size - 1} {
+			user := ChunkUser{}
+			has, err := engine.Id(users[i].Id).Get(&user)
+			if err != nil || !has || user.Name != users[i].Name {
+				err = errors.New(fmt.Sprintf("should get %v by the generated id but %v %v %v", users[i], user, has, err))
+				t.Error(err)
+				panic(err)
+			}
+		}
+	}
+
+	// the last chunk fails, so all the chunks are rolled back
+	users = make([]ChunkUser, size)
+	for i := range users {
+		users[i] = ChunkUser{Name: fmt.Sprintf("other%v", i)}
+	}
+	users[size-1].Name = "user0"
+	affected, err = engine.ChunksInTx().Insert(&users)
+	if err == nil {
+		err = errors.New("should fail by the duplicated name")
+		t.Error(err)
+		panic(err)
+	}
+	for _, user := range users {
+		if affected != 0 || user.Id != 0 {
+			err = errors.New(fmt.Sprintf("should reset the rollbacked ids but %v %v", affected, user))
+			t.Error(err)
+			panic(err)
+		}
+	}
+	total, err = engine.Count(new(ChunkUser))
+	if err != nil || total != int64(size) {
+		err = errors.New(fmt.Sprintf("should roll back all the chunks but %v %v", total, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	// the session could be used without a transaction after the chunks
+	session := engine.NewSession()
+	defer session.Close()
+	for i := range users {
+		users[i] = ChunkUser{Name: fmt.Sprintf("other%v", i)}
+	}
+	_, err = session.ChunksInTx().Insert(&users)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = session.Insert(&ChunkUser{Name: "after chunks"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	_, err = session.Where("name = ?", "after chunks").Delete(new(ChunkUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	total, err = engine.Count(new(ChunkUser))
+	if err != nil || total != int64(2*size) {
+		err = errors.New(fmt.Sprintf("should have %v users but %v %v", 2*size, total, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	testChunkRetry(engine, t)
+}
+
+// failRetryMark makes the RetryMark "fail" fail once by a retryable error
+var failRetryMark bool
+
+type RetryMark string
+
+func (mark RetryMark) Value() (driver.Value, error) {
+	if mark == "" {
+		return nil, nil
+	}
+	if mark == "fail" && failRetryMark {
+		failRetryMark = false
+		return nil, &retryableError{1213, "40001"}
+	}
+	return string(mark), nil
+}
+
+type RetryChunkUser struct {
+	Id   int64
+	Name string
+	Mark RetryMark `xorm:"varchar(10)"`
+}
+
+// the chunks are inserted again when the transaction is retried
+func testChunkRetry(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(RetryChunkUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(RetryChunkUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	oldRetries := engine.TxRetries
+	defer func() {
+		engine.TxRetries = oldRetries
+	}()
+	engine.TxRetries = 1
+	failRetryMark = true
+
+	size := engine.dialect.MaxInsertArgs()/3 + 100
+	users := make([]RetryChunkUser, size)
+	for i := range users {
+		users[i] = RetryChunkUser{Name: fmt.Sprintf("user%v", i)}
+	}
+	users[size-1].Mark = "fail"
+	affected, err := engine.ChunksInTx().Insert(&users)
+	if err != nil || failRetryMark {
+		err = errors.New(fmt.Sprintf("should insert by the retry but %v %v", failRetryMark, err))
+		t.Error(err)
+		panic(err)
+	}
+	total, err := engine.Count(new(RetryChunkUser))
+	if err != nil || affected != int64(size) || total != int64(size) {
+		err = errors.New(fmt.Sprintf("should insert %v users once but %v %v %v", size, affected, total, err))
+		t.Error(err)
+		panic(err)
+	}
+	if engine.dialect.SupportReturning() || engine.dialect.ConsecutiveInsertIds() {
+		for _, i := range []int{0, size - 1} {
+			user := RetryChunkUser{}
+			has, err := engine.NoCache().Id(users[i].Id).Get(&user)
+			if err != nil || !has || user.Name != users[i].Name {
+				err = errors.New(fmt.Sprintf("should get %v by the retried id but %v %v %v", users[i], user, has, err))
+				t.Error(err)
+				panic(err)
+			}
+		}
+	}
+}
+
+// NullTags is stored as a comma separated string by driver.Valuer and
+// sql.Scanner
+type NullTags []string
+
+func (tags NullTags) Value() (driver.Value, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	return strings.Join(tags, ","), nil
+}
+
+func (tags *NullTags) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case nil:
+		*tags = nil
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("can't scan %v to NullTags", src))
+	}
+	*tags = NullTags(strings.Split(s, ","))
+	return nil
+}
+
+type NullUser struct {
+	Id    int64
+	Name  *string
+	Age   *int
+	Nick  sql.NullString
+	Score sql.NullInt64
+	Tags  NullTags `xorm:"varchar(255)"`
+	At    *time.Time
+}
+
+func testNullable(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(NullUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(NullUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	user := NullUser{Tags: NullTags{"a", "b"}}
+	_, err = engine.Insert(&user)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	loaded := NullUser{}
+	has, err := engine.Id(user.Id).Get(&loaded)
+	if err != nil || !has || loaded.Name != nil || loaded.Age != nil || loaded.Nick.Valid ||
+		loaded.Score.Valid || strings.Join(loaded.Tags, ",") != "a,b" {
+		err = errors.New(fmt.Sprintf("should get the null fields but %v %v %v", loaded, has, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	// a pointer to zero is updated and is a condition
+	name, age := "lunny", 0
+	_, err = engine.Id(user.Id).Update(&NullUser{Name: &name, Age: &age,
+		Nick: sql.NullString{String: "xlw", Valid: true}, Score: sql.NullInt64{Int64: 0, Valid: true}})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	users := make([]NullUser, 0)
+	err = engine.Find(&users, &NullUser{Age: &age, Score: sql.NullInt64{Valid: true}})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(users) != 1 || users[0].Name == nil || *users[0].Name != name || users[0].Age == nil ||
+		*users[0].Age != 0 || users[0].Nick.String != "xlw" || !users[0].Score.Valid {
+		err = errors.New(fmt.Sprintf("should find the updated user but %v", users))
+		t.Error(err)
+		panic(err)
+	}
+
+	// nil pointers are written as null by Cols
+	_, err = engine.Id(user.Id).Cols("name", "age", "nick").Update(&NullUser{})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	has, err = engine.Id(user.Id).Get(&loaded)
+	if err != nil || !has || loaded.Name != nil || loaded.Age != nil || loaded.Nick.Valid || !loaded.Score.Valid {
+		err = errors.New(fmt.Sprintf("should get the nulled fields but %v %v %v", loaded, has, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	// a pointer to a time is a condition as the time
+	at := time.Now()
+	_, err = engine.Insert(&NullUser{At: &at})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	has, err = engine.NoCache().Get(&NullUser{At: &at})
+	if err != nil || !has {
+		err = errors.New(fmt.Sprintf("should get the user by the time pointer but %v %v", has, err))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type ScanUser struct {
+	Id   int64
+	Code string
+	Num  int `xorm:"varchar(10)"`
+	Nick *string
+}
+
+func testDirectScan(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(ScanUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(ScanUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	empty := ""
+	_, err = engine.Insert(&ScanUser{Code: "007", Nick: &empty}, &ScanUser{Code: "008"})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	// the leading zero is not an octal prefix
+	_, err = engine.Exec("UPDATE " + engine.Quote("scan_user") + " SET " + engine.Quote("num") + " = '010'")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	users := make([]ScanUser, 0)
+	err = engine.Asc("id").Find(&users)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(users) != 2 || users[0].Code != "007" || users[0].Num != 10 || users[0].Nick == nil ||
+		*users[0].Nick != "" || users[1].Nick != nil {
+		err = errors.New(fmt.Sprintf("should find 007 with empty nick and 008 with null nick but %v", users))
+		t.Error(err)
+		panic(err)
+	}
+
+	// another column list is scanned by another plan
+	codes := make(map[int64]*ScanUser)
+	err = engine.NoCache().Cols("id", "code").Find(&codes)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(codes) != 2 || codes[users[1].Id].Code != "008" || codes[users[1].Id].Num != 0 {
+		err = errors.New(fmt.Sprintf("should find the codes by id but %v", codes))
+		t.Error(err)
+		panic(err)
+	}
+
+	count := 0
+	err = engine.Iterate(new(ScanUser), func(idx int, bean interface{}) error {
+		if bean.(*ScanUser).Num != 10 {
+			return errors.New(fmt.Sprintf("should iterate num 10 but %v", bean))
+		}
+		count++
+		return nil
+	})
+	if err != nil || count != 2 {
+		err = errors.New(fmt.Sprintf("should iterate 2 users but %v %v", count, err))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type StmtUser struct {
+	Id   int64
+	Name string
+}
+
+func testStmtCache(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(StmtUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(StmtUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	engine.SetStmtCache(2)
+	defer engine.SetStmtCache(0)
+
+	tableName := engine.Quote("stmt_user")
+	insertSql := "INSERT INTO " + tableName + " (" + engine.Quote("name") + ") VALUES (?)"
+	sqls := []string{
+		"SELECT * FROM " + tableName + " WHERE " + engine.Quote("id") + " > ?",
+		"SELECT * FROM " + tableName + " WHERE " + engine.Quote("id") + " < ?",
+		"SELECT * FROM " + tableName + " WHERE " + engine.Quote("id") + " = ?",
+	}
+	check := func(stats StmtCacheStats, hits, misses int64) {
+		now := engine.StmtCacheStats()
+		if now.Hits-stats.Hits != hits || now.Misses-stats.Misses != misses {
+			err := errors.New(fmt.Sprintf("should have %v hits and %v misses but %v", hits, misses,
+				StmtCacheStats{now.Hits - stats.Hits, now.Misses - stats.Misses}))
+			t.Error(err)
+			panic(err)
+		}
+	}
+
+	stats := engine.StmtCacheStats()
+	for _, name := range []string{"a", "b", "c"} {
+		_, err = engine.Exec(insertSql, name)
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+	}
+	check(stats, 2, 1)
+
+	// the first query is evicted by the third one
+	stats = engine.StmtCacheStats()
+	for _, i := range []int{0, 1, 2, 0, 2} {
+		results, err := engine.Query(sqls[i], 1)
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+		if i == 2 && len(results) != 1 {
+			err = errors.New(fmt.Sprintf("should query 1 user but %v", results))
+			t.Error(err)
+			panic(err)
+		}
+	}
+	check(stats, 1, 4)
+
+	// the statements of a transaction are dropped when it ends
+	for i := 0; i < 2; i++ {
+		stats = engine.StmtCacheStats()
+		session := engine.NewSession()
+		err = session.Begin()
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+		for _, name := range []string{"d", "e"} {
+			_, err = session.Exec(insertSql, name)
+			if err != nil {
+				session.Close()
+				t.Error(err)
+				panic(err)
+			}
+		}
+		if i == 0 {
+			err = session.Commit()
+		} else {
+			err = session.Rollback()
+		}
+		if err == nil && session.txStmts != nil {
+			err = errors.New("should drop the statements of the transaction")
+		}
+		session.Close()
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+		check(stats, 1, 1)
+	}
+
+	total, err := engine.Count(new(StmtUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if total != 5 {
+		err = errors.New(fmt.Sprintf("should count 5 users but %v", total))
+		t.Error(err)
+		panic(err)
+	}
+
+	engine.SetStmtCache(0)
+	stats = engine.StmtCacheStats()
+	_, err = engine.Exec(insertSql, "f")
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	check(stats, 0, 0)
+	if len(engine.stmtCaches) != 0 {
+		err = errors.New(fmt.Sprintf("should close the cached statements but %v", engine.stmtCaches))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type ZoneUser struct {
+	Id      int64
+	Stamp   time.Time
+	Day     time.Time `xorm:"date"`
+	Created time.Time `xorm:"created"`
+	Expire  *time.Time
+}
+
+func testTimeZone(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(ZoneUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(ZoneUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	appTZ := time.FixedZone("app", 8*3600)
+	dbTZ := time.FixedZone("db", -5*3600)
+	tzLocation, databaseTZ := engine.TZLocation, engine.DatabaseTZ
+	engine.TZLocation, engine.DatabaseTZ = appTZ, dbTZ
+	defer func() {
+		engine.TZLocation, engine.DatabaseTZ = tzLocation, databaseTZ
+	}()
+
+	stamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	day := time.Date(2020, 1, 2, 23, 0, 0, 0, appTZ)
+	before := time.Now().Truncate(time.Second)
+	user := ZoneUser{Stamp: stamp, Day: day, Expire: &stamp}
+	_, err = engine.Insert(&user)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	after := time.Now()
+
+	// the time is stored in the database's time zone
+	results, err := engine.Query("SELECT " + engine.Quote("stamp") + " FROM " + engine.Quote("zone_user"))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(results) != 1 || !strings.HasPrefix(string(results[0]["stamp"]), "2020-01-01 22:04:05") {
+		err = errors.New(fmt.Sprintf("should store the time in the database's time zone but %v", results))
+		t.Error(err)
+		panic(err)
+	}
+
+	// the time condition is converted as well
+	var got ZoneUser
+	has, err := engine.NoCache().Get(&ZoneUser{Stamp: stamp})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if !has {
+		err = errors.New("should get the user by the time")
+		t.Error(err)
+		panic(err)
+	}
+	has, err = engine.NoCache().Get(&ZoneUser{Expire: &stamp})
+	if err != nil || !has {
+		err = errors.New(fmt.Sprintf("should get the user by the time pointer but %v %v", has, err))
+		t.Error(err)
+		panic(err)
+	}
+	has, err = engine.NoCache().Id(user.Id).Get(&got)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if !has || !got.Stamp.Equal(stamp) || got.Stamp.Location() != appTZ {
+		err = errors.New(fmt.Sprintf("should read the time in the application's time zone but %v", got.Stamp))
+		t.Error(err)
+		panic(err)
+	}
+	if got.Day.Format("2006-01-02") != "2020-01-02" || got.Day.Location() != appTZ {
+		err = errors.New(fmt.Sprintf("should read the date in the application's time zone but %v", got.Day))
+		t.Error(err)
+		panic(err)
+	}
+	if got.Created.Before(before) || got.Created.After(after) || got.Created.Location() != appTZ {
+		err = errors.New(fmt.Sprintf("should read the created time between %v and %v but %v", before, after, got.Created))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+type ConvertUser struct {
+	Id   int64
+	Ip   net.IP
+	Home *url.URL
+}
+
+func testConverter(engine *Engine, t *testing.T) {
+	engine.RegisterConverter(reflect.TypeOf(net.IP{}), func(v interface{}) ([]byte, error) {
+		return []byte(v.(net.IP).String()), nil
+	}, func(data []byte) (interface{}, error) {
+		ip := net.ParseIP(string(data))
+		if ip == nil {
+			return nil, errors.New("invalid ip " + string(data))
+		}
+		return ip, nil
+	}, SQLType{Varchar, 45, 0})
+	engine.RegisterConverter(reflect.TypeOf(url.URL{}), func(v interface{}) ([]byte, error) {
+		u := v.(url.URL)
+		return []byte(u.String()), nil
+	}, func(data []byte) (interface{}, error) {
+		u, err := url.Parse(string(data))
+		if err != nil {
+			return nil, err
+		}
+		return *u, nil
+	}, SQLType{Varchar, 255, 0})
+
+	err := engine.DropTables(new(ConvertUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(ConvertUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	table := engine.autoMap(new(ConvertUser))
+	if col := table.Columns["ip"]; col.SQLType.Name != Varchar || col.Length != 45 {
+		err = errors.New(fmt.Sprintf("should map ip to VARCHAR(45) but %v", col.SQLType))
+		t.Error(err)
+		panic(err)
+	}
+
+	home, _ := url.Parse("https://example.com/a?b=1")
+	users := []ConvertUser{{Ip: net.ParseIP("192.168.1.1"), Home: home}, {}}
+	for i := range users {
+		_, err = engine.Insert(&users[i])
+		if err != nil {
+			t.Error(err)
+			panic(err)
+		}
+	}
+
+	sql := "SELECT " + engine.Quote("ip") + " FROM " + engine.Quote("convert_user") +
+		" WHERE " + engine.Quote("id") + " = ?"
+	results, err := engine.Query(sql, users[0].Id)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(results) != 1 || string(results[0]["ip"]) != "192.168.1.1" {
+		err = errors.New(fmt.Sprintf("should store the ip as a string but %v", results))
+		t.Error(err)
+		panic(err)
+	}
+
+	var got ConvertUser
+	has, err := engine.Get(&ConvertUser{Ip: net.ParseIP("192.168.1.1")})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if !has {
+		err = errors.New("should get the user by the ip")
+		t.Error(err)
+		panic(err)
+	}
+	has, err = engine.Id(users[0].Id).Get(&got)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if !has || !got.Ip.Equal(users[0].Ip) || got.Home == nil || got.Home.String() != home.String() {
+		err = errors.New(fmt.Sprintf("should get the ip and the url but %v", got))
+		t.Error(err)
+		panic(err)
+	}
+
+	_, err = engine.Id(users[1].Id).Update(&ConvertUser{Ip: net.ParseIP("10.0.0.1")})
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	found := make([]ConvertUser, 0)
+	err = engine.Asc("id").Find(&found)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	if len(found) != 2 || !found[1].Ip.Equal(net.ParseIP("10.0.0.1")) || found[1].Home != nil {
+		err = errors.New(fmt.Sprintf("should find the updated ip and the null url but %v", found))
+		t.Error(err)
+		panic(err)
+	}
+
+	// the converters are not shared by other engines
+	other, err := NewEngine(engine.DriverName, engine.DataSourceName)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	defer other.Close()
+	if col := other.autoMap(new(ConvertUser)).Columns["ip"]; col.SQLType.Name == Varchar && col.Length == 45 {
+		err = errors.New("should not map ip by the converter of another engine")
+		t.Error(err)
+		panic(err)
+	}
+
+	testLateConverter(engine, t)
+}
+
+// ConvertTag is a Scanner whose converter is registered after it's scanned
+type ConvertTag string
+
+func (tag *ConvertTag) Scan(src interface{}) error {
+	*tag = ConvertTag(fmt.Sprintf("scanned %s", src))
+	return nil
+}
+
+type ConvertTagUser struct {
+	Id  int64
+	Tag ConvertTag
+}
+
+func testLateConverter(engine *Engine, t *testing.T) {
+	err := engine.DropTables(new(ConvertTagUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	err = engine.CreateTables(new(ConvertTagUser))
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+	user := ConvertTagUser{Tag: "a"}
+	_, err = engine.Insert(&user)
+	if err != nil {
+		t.Error(err)
+		panic(err)
+	}
+
+	var got ConvertTagUser
+	has, err := engine.NoCache().Id(user.Id).Get(&got)
+	if err != nil || !has || got.Tag != "scanned a" {
+		err = errors.New(fmt.Sprintf("should scan the tag but %v %v %v", got, has, err))
+		t.Error(err)
+		panic(err)
+	}
+
+	engine.RegisterConverter(reflect.TypeOf(ConvertTag("")), func(v interface{}) ([]byte, error) {
+		return []byte(v.(ConvertTag)), nil
+	}, func(data []byte) (interface{}, error) {
+		return ConvertTag("converted " + string(data)), nil
+	}, SQLType{Varchar, 255, 0})
+	got = ConvertTagUser{}
+	has, err = engine.NoCache().Id(user.Id).Get(&got)
+	if err != nil || !has || got.Tag != "converted a" {
+		err = errors.New(fmt.Sprintf("should convert the tag by the converter registered later but %v %v %v", got, has, err))
+		t.Error(err)
+		panic(err)
+	}
+}
+
+func testExtendsPks(engine *Engine, t *testing.T) {
+	table := engine.autoMap(new(JoinUserGroup))
+	if len(table.PrimaryKeys) != 1 || table.PrimaryKeys[0] != "id" {
+		err := errors.New(fmt.Sprintf("should have the primary key id but %v", table.PrimaryKeys))
+		t.Error(err)
+		panic(err)
+	}
+	if strings.Join(table.ColumnsSeq, ",") != "id,name,group_id" {
+		err := errors.New(fmt.Sprintf("should have the columns id, name and group_id but %v", table.ColumnsSeq))
+		t.Error(err)
+		panic(err)
+	}
+
+	session := engine.NewSession()
+	defer session.Close()
+	session.Statement.RefTable = table
+	sql := session.Statement.genCreateSQL()
+	if strings.Count(sql, engine.Quote("id")) != 1 || strings.Contains(sql, "PRIMARY KEY (") {
+		err := errors.New(fmt.Sprintf("should create the table with one primary key id but %v", sql))
+		t.Error(err)
+		panic(err)
+	}
+}
+
 func testAll2(engine *Engine, t *testing.T) {
 	fmt.Println("-------------- combineTransaction --------------")
 	combineTransaction(engine, t)
@@ -1693,6 +4906,60 @@ func testAll2(engine *Engine, t *testing.T) {
 	testUseBool(engine, t)
 	fmt.Println("-------------- testBool --------------")
 	testBool(engine, t)
+	fmt.Println("-------------- testCompositeKey --------------")
+	testCompositeKey(engine, t)
+	fmt.Println("-------------- testStringPK --------------")
+	testStringPK(engine, t)
+	fmt.Println("-------------- testUint64Id --------------")
+	testUint64Id(engine, t)
+	fmt.Println("-------------- testContext --------------")
+	testContext(engine, t)
+	fmt.Println("-------------- testMigrate --------------")
+	testMigrate(engine, t)
+	fmt.Println("-------------- testSyncAlter --------------")
+	testSyncAlter(engine, t)
+	fmt.Println("-------------- testDiffSchema --------------")
+	testDiffSchema(engine, t)
+	fmt.Println("-------------- testCond --------------")
+	testCond(engine, t)
+	fmt.Println("-------------- testSubQuery --------------")
+	testSubQuery(engine, t)
+	fmt.Println("-------------- testJoinExtends --------------")
+	testJoinExtends(engine, t)
+	fmt.Println("-------------- testExtendsPks --------------")
+	testExtendsPks(engine, t)
+	fmt.Println("-------------- testPreload --------------")
+	testPreload(engine, t)
+	fmt.Println("-------------- testSavepoint --------------")
+	testSavepoint(engine, t)
+	fmt.Println("-------------- testTxHelper --------------")
+	testTxHelper(engine, t)
+	fmt.Println("-------------- testIsolation --------------")
+	testIsolation(engine, t)
+	fmt.Println("-------------- testEngineGroup --------------")
+	testEngineGroup(engine, t)
+	fmt.Println("-------------- testShard --------------")
+	testShard(engine, t)
+	fmt.Println("-------------- testHooks --------------")
+	testHooks(engine, t)
+	fmt.Println("-------------- testSoftDelete --------------")
+	testSoftDelete(engine, t)
+	fmt.Println("-------------- testOptimisticLock --------------")
+	testOptimisticLock(engine, t)
+	fmt.Println("-------------- testUpsert --------------")
+	testUpsert(engine, t)
+	fmt.Println("-------------- testChunkInsert --------------")
+	testChunkInsert(engine, t)
+	fmt.Println("-------------- testNullable --------------")
+	testNullable(engine, t)
+	fmt.Println("-------------- testDirectScan --------------")
+	testDirectScan(engine, t)
+	fmt.Println("-------------- testStmtCache --------------")
+	testStmtCache(engine, t)
+	fmt.Println("-------------- testTimeZone --------------")
+	testTimeZone(engine, t)
+	fmt.Println("-------------- testConverter --------------")
+	testConverter(engine, t)
 	fmt.Println("-------------- transaction --------------")
 	transaction(engine, t)
 }
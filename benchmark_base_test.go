@@ -47,3 +47,87 @@ func doBenchCacheFind(engine *Engine, b *testing.B) {
 		return
 	}
 }
+
+func prepareBenchFind(engine *Engine, b *testing.B) bool {
+	bs := &BigStruct{0, "fafdasf", "fadfa", "afadfsaf", "fadfafdsafd", "fadfafdsaf"}
+	err := engine.CreateTables(bs)
+	if err != nil {
+		b.Error(err)
+		return false
+	}
+
+	for i := 0; i < 100; i++ {
+		bs.Id = 0
+		_, err = engine.Insert(bs)
+		if err != nil {
+			b.Error(err)
+			return false
+		}
+	}
+	return true
+}
+
+// the rows are scanned into the structs directly
+func doBenchFind(engine *Engine, b *testing.B) {
+	b.StopTimer()
+	if !prepareBenchFind(engine, b) {
+		return
+	}
+
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		bss := make([]BigStruct, 0)
+		err := engine.NoCache().Sql("select * from " + engine.Quote("big_struct")).Find(&bss)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+	}
+	b.StopTimer()
+	err := engine.DropTables(new(BigStruct))
+	if err != nil {
+		b.Error(err)
+		return
+	}
+}
+
+// the rows are queried as maps and converted to the structs, the way Find
+// scanned the rows before
+func doBenchFindByMap(engine *Engine, b *testing.B) {
+	b.StopTimer()
+	if !prepareBenchFind(engine, b) {
+		return
+	}
+	table := engine.autoMap(new(BigStruct))
+	session := engine.NewSession()
+	defer session.Close()
+
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		results, err := engine.Query("select * from " + engine.Quote("big_struct"))
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		bss := make([]BigStruct, len(results))
+		for j, result := range results {
+			for name, data := range result {
+				col := table.Columns[name]
+				fieldValue := col.ValueOf(&bss[j])
+				err = session.bytes2Value(col, &fieldValue, data)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}
+	}
+	b.StopTimer()
+	err := engine.DropTables(new(BigStruct))
+	if err != nil {
+		b.Error(err)
+		return
+	}
+}
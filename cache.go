@@ -1,11 +1,11 @@
 package xorm
 
 import (
+	"bytes"
 	"container/list"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 	"sync"
 	"time"
 )
@@ -63,21 +63,23 @@ func (s *MemoryStore) Del(key interface{}) error {
 	return nil
 }
 
-// Cacher is an interface to provide cache
+// Cacher is an interface to provide cache, id is a PK encoded by
+// PK.ToString so that both non-integer and composite primary keys
+// could be cached
 type Cacher interface {
 	GetIds(tableName, sql string) interface{}
-	GetBean(tableName string, id int64) interface{}
+	GetBean(tableName string, id string) interface{}
 	PutIds(tableName, sql string, ids interface{})
-	PutBean(tableName string, id int64, obj interface{})
+	PutBean(tableName string, id string, obj interface{})
 	DelIds(tableName, sql string)
-	DelBean(tableName string, id int64)
+	DelBean(tableName string, id string)
 	ClearIds(tableName string)
 	ClearBeans(tableName string)
 }
 
 type idNode struct {
 	tbName    string
-	id        int64
+	id        string
 	lastVisit time.Time
 }
 
@@ -87,7 +89,7 @@ type sqlNode struct {
 	lastVisit time.Time
 }
 
-func newIdNode(tbName string, id int64) *idNode {
+func newIdNode(tbName string, id string) *idNode {
 	return &idNode{tbName, id, time.Now()}
 }
 
@@ -209,7 +211,7 @@ func (m *LRUCacher) GetIds(tableName, sql string) interface{} {
 }
 
 // Get bean according tableName and id from cache
-func (m *LRUCacher) GetBean(tableName string, id int64) interface{} {
+func (m *LRUCacher) GetBean(tableName string, id string) interface{} {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	if _, ok := m.idIndex[tableName]; !ok {
@@ -261,7 +263,7 @@ func (m *LRUCacher) clearBeans(tableName string) {
 	if tis, ok := m.idIndex[tableName]; ok {
 		for id, v := range tis {
 			m.idList.Remove(v)
-			tid := genId(tableName, id.(int64))
+			tid := genId(tableName, id.(string))
 			m.store.Del(tid)
 		}
 	}
@@ -294,7 +296,7 @@ func (m *LRUCacher) PutIds(tableName, sql string, ids interface{}) {
 	}
 }
 
-func (m *LRUCacher) PutBean(tableName string, id int64, obj interface{}) {
+func (m *LRUCacher) PutBean(tableName string, id string, obj interface{}) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	var el *list.Element
@@ -331,7 +333,7 @@ func (m *LRUCacher) DelIds(tableName, sql string) {
 	m.delIds(tableName, sql)
 }
 
-func (m *LRUCacher) delBean(tableName string, id int64) {
+func (m *LRUCacher) delBean(tableName string, id string) {
 	tid := genId(tableName, id)
 	if el, ok := m.idIndex[tableName][id]; ok {
 		delete(m.idIndex[tableName], id)
@@ -341,47 +343,41 @@ func (m *LRUCacher) delBean(tableName string, id int64) {
 	m.store.Del(tid)
 }
 
-func (m *LRUCacher) DelBean(tableName string, id int64) {
+func (m *LRUCacher) DelBean(tableName string, id string) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	m.delBean(tableName, id)
 }
 
-func encodeIds(ids []int64) (s string) {
-	s = "["
-	for _, id := range ids {
-		s += fmt.Sprintf("%v,", id)
+func encodeIds(ids []PK) (string, error) {
+	bs, err := json.Marshal(ids)
+	if err != nil {
+		return "", err
 	}
-	s = s[:len(s)-1] + "]"
-	return
-}
-
-func decodeIds(s string) []int64 {
-	res := make([]int64, 0)
-	if len(s) >= 2 {
-		ss := strings.Split(s[1:len(s)-1], ",")
-		for _, s := range ss {
-			i, err := strconv.ParseInt(s, 10, 64)
-			if err != nil {
-				return res
-			}
-			res = append(res, i)
-		}
-	}
-	return res
+	return string(bs), nil
 }
 
-func getCacheSql(m Cacher, tableName, sql string, args interface{}) ([]int64, error) {
+func decodeIds(s string) ([]PK, error) {
+	res := make([]PK, 0)
+	dec := json.NewDecoder(bytes.NewBufferString(s))
+	dec.UseNumber()
+	err := dec.Decode(&res)
+	return res, err
+}
+
+func getCacheSql(m Cacher, tableName, sql string, args interface{}) ([]PK, error) {
 	bytes := m.GetIds(tableName, genSqlKey(sql, args))
 	if bytes == nil {
 		return nil, errors.New("Not Exist")
 	}
-	objs := decodeIds(bytes.(string))
-	return objs, nil
+	return decodeIds(bytes.(string))
 }
 
-func putCacheSql(m Cacher, ids []int64, tableName, sql string, args interface{}) error {
-	bytes := encodeIds(ids)
+func putCacheSql(m Cacher, ids []PK, tableName, sql string, args interface{}) error {
+	bytes, err := encodeIds(ids)
+	if err != nil {
+		return err
+	}
 	m.PutIds(tableName, genSqlKey(sql, args), bytes)
 	return nil
 }
@@ -390,6 +386,6 @@ func genSqlKey(sql string, args interface{}) string {
 	return fmt.Sprintf("%v-%v", sql, args)
 }
 
-func genId(prefix string, id int64) string {
+func genId(prefix string, id string) string {
 	return fmt.Sprintf("%v-%v", prefix, id)
 }
@@ -0,0 +1,291 @@
+package xorm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Cond is a condition which could be passed to Where, And and Or as well as
+// the string form, for example:
+//
+//		engine.Where(xorm.Or(xorm.Eq{"name": "lunny"}, xorm.Gt{"age": 18})).Find(&users)
+//
+// will generate "WHERE (`name`=?) OR (`age`>?)". ToSql returns the sql and args
+// of the condition, the column names are quoted by quote.
+type Cond interface {
+	ToSql(quote func(string) string) (string, []interface{})
+}
+
+// quote a column name, a "table.column" name will be quoted separately,
+// expressions like "count(id)" will not be quoted
+func quoteColumn(quote func(string) string, col string) string {
+	col = strings.TrimSpace(col)
+	if strings.ContainsAny(col, "()`\" ") {
+		return col
+	}
+	parts := strings.Split(col, ".")
+	for i, part := range parts {
+		parts[i] = quote(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// generate "col op ?" for every column joined by AND
+func compareToSql(quote func(string) string, m map[string]interface{}, op string) (string, []interface{}) {
+	conds := make([]string, 0, len(m))
+	args := make([]interface{}, 0, len(m))
+	for _, col := range sortedKeys(m) {
+		conds = append(conds, quoteColumn(quote, col)+op+"?")
+		args = append(args, m[col])
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// Eq generates "col=?" for every column, a nil value generates "col IS NULL"
+type Eq map[string]interface{}
+
+func (eq Eq) ToSql(quote func(string) string) (string, []interface{}) {
+	conds := make([]string, 0, len(eq))
+	args := make([]interface{}, 0, len(eq))
+	for _, col := range sortedKeys(eq) {
+		if eq[col] == nil {
+			conds = append(conds, quoteColumn(quote, col)+" IS NULL")
+			continue
+		}
+		conds = append(conds, quoteColumn(quote, col)+"=?")
+		args = append(args, eq[col])
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// Neq generates "col<>?" for every column, a nil value generates "col IS NOT NULL"
+type Neq map[string]interface{}
+
+func (neq Neq) ToSql(quote func(string) string) (string, []interface{}) {
+	conds := make([]string, 0, len(neq))
+	args := make([]interface{}, 0, len(neq))
+	for _, col := range sortedKeys(neq) {
+		if neq[col] == nil {
+			conds = append(conds, quoteColumn(quote, col)+" IS NOT NULL")
+			continue
+		}
+		conds = append(conds, quoteColumn(quote, col)+"<>?")
+		args = append(args, neq[col])
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// Gt generates "col>?" for every column
+type Gt map[string]interface{}
+
+func (gt Gt) ToSql(quote func(string) string) (string, []interface{}) {
+	return compareToSql(quote, gt, ">")
+}
+
+// Gte generates "col>=?" for every column
+type Gte map[string]interface{}
+
+func (gte Gte) ToSql(quote func(string) string) (string, []interface{}) {
+	return compareToSql(quote, gte, ">=")
+}
+
+// Lt generates "col<?" for every column
+type Lt map[string]interface{}
+
+func (lt Lt) ToSql(quote func(string) string) (string, []interface{}) {
+	return compareToSql(quote, lt, "<")
+}
+
+// Lte generates "col<=?" for every column
+type Lte map[string]interface{}
+
+func (lte Lte) ToSql(quote func(string) string) (string, []interface{}) {
+	return compareToSql(quote, lte, "<=")
+}
+
+// Like generates "col LIKE ?", Like{"name", "%lunny%"}
+type Like [2]string
+
+func (like Like) ToSql(quote func(string) string) (string, []interface{}) {
+	return quoteColumn(quote, like[0]) + " LIKE ?", []interface{}{like[1]}
+}
+
+// Between generates "col BETWEEN ? AND ?"
+type Between struct {
+	Col  string
+	Less interface{}
+	More interface{}
+}
+
+func (between Between) ToSql(quote func(string) string) (string, []interface{}) {
+	return quoteColumn(quote, between.Col) + " BETWEEN ? AND ?",
+		[]interface{}{between.Less, between.More}
+}
+
+// IsNull generates "col IS NULL" for every column
+type IsNull []string
+
+func (isNull IsNull) ToSql(quote func(string) string) (string, []interface{}) {
+	conds := make([]string, 0, len(isNull))
+	for _, col := range isNull {
+		conds = append(conds, quoteColumn(quote, col)+" IS NULL")
+	}
+	return strings.Join(conds, " AND "), []interface{}{}
+}
+
+// NotNull generates "col IS NOT NULL" for every column
+type NotNull []string
+
+func (notNull NotNull) ToSql(quote func(string) string) (string, []interface{}) {
+	conds := make([]string, 0, len(notNull))
+	for _, col := range notNull {
+		conds = append(conds, quoteColumn(quote, col)+" IS NOT NULL")
+	}
+	return strings.Join(conds, " AND "), []interface{}{}
+}
+
+type condIn struct {
+	col    string
+	values []interface{}
+	not    bool
+}
+
+// In generates "col IN (?, ?)", an empty In is always false. If the only value
+// is a *Session or *Statement, "col IN (sub query)" is generated
+func In(col string, values ...interface{}) Cond {
+	return condIn{col, values, false}
+}
+
+// NotIn generates "col NOT IN (?, ?)", an empty NotIn is always true
+func NotIn(col string, values ...interface{}) Cond {
+	return condIn{col, values, true}
+}
+
+func (in condIn) ToSql(quote func(string) string) (string, []interface{}) {
+	if len(in.values) == 0 {
+		if in.not {
+			return "1=1", []interface{}{}
+		}
+		return "1=0", []interface{}{}
+	}
+	op := " IN "
+	if in.not {
+		op = " NOT IN "
+	}
+	if len(in.values) == 1 && isSubQuery(in.values[0]) {
+		return quoteColumn(quote, in.col) + op + "?", in.values
+	}
+	return quoteColumn(quote, in.col) + op + "(" + strings.Join(makeArray("?", len(in.values)), ", ") + ")",
+		in.values
+}
+
+type condExists struct {
+	sub interface{}
+	not bool
+}
+
+// Exists generates "EXISTS (sub query)", sub is a *Session or *Statement
+func Exists(sub interface{}) Cond {
+	return condExists{sub, false}
+}
+
+// NotExists generates "NOT EXISTS (sub query)", sub is a *Session or *Statement
+func NotExists(sub interface{}) Cond {
+	return condExists{sub, true}
+}
+
+func (exists condExists) ToSql(quote func(string) string) (string, []interface{}) {
+	if exists.not {
+		return "NOT EXISTS ?", []interface{}{exists.sub}
+	}
+	return "EXISTS ?", []interface{}{exists.sub}
+}
+
+type condJoin struct {
+	op    string
+	conds []Cond
+}
+
+// And joins the conditions by AND, every condition will be in brackets
+func And(conds ...Cond) Cond {
+	return condJoin{" AND ", conds}
+}
+
+// Or joins the conditions by OR, every condition will be in brackets
+func Or(conds ...Cond) Cond {
+	return condJoin{" OR ", conds}
+}
+
+func (join condJoin) ToSql(quote func(string) string) (string, []interface{}) {
+	conds := make([]string, 0, len(join.conds))
+	args := make([]interface{}, 0)
+	for _, cond := range join.conds {
+		sql, condArgs := cond.ToSql(quote)
+		if sql == "" {
+			continue
+		}
+		conds = append(conds, sql)
+		args = append(args, condArgs...)
+	}
+	if len(conds) == 1 {
+		return conds[0], args
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return "(" + strings.Join(conds, ")"+join.op+"(") + ")", args
+}
+
+type condNot struct {
+	cond Cond
+}
+
+// Not generates "NOT (cond)"
+func Not(cond Cond) Cond {
+	return condNot{cond}
+}
+
+func (not condNot) ToSql(quote func(string) string) (string, []interface{}) {
+	sql, args := not.cond.ToSql(quote)
+	if sql == "" {
+		return "", args
+	}
+	return "NOT (" + sql + ")", args
+}
+
+type condExpr struct {
+	sql  string
+	args []interface{}
+}
+
+// Expr is a raw sql condition, it could be combined with the other conditions
+func Expr(sql string, args ...interface{}) Cond {
+	return condExpr{sql, args}
+}
+
+func (expr condExpr) ToSql(quote func(string) string) (string, []interface{}) {
+	return expr.sql, expr.args
+}
+
+// convert a query which is a string or a Cond to sql and args
+func condToSql(quote func(string) string, query interface{}, args []interface{}) (string, []interface{}) {
+	switch q := query.(type) {
+	case string:
+		return q, args
+	case Cond:
+		sql, condArgs := q.ToSql(quote)
+		return sql, append(condArgs, args...)
+	default:
+		return fmt.Sprintf("%v", query), args
+	}
+}
@@ -0,0 +1,93 @@
+package xorm
+
+import (
+	"errors"
+	"reflect"
+)
+
+// a converter of a type which can't implement Conversion, like the types of
+// other packages
+type converter struct {
+	toDB    func(interface{}) ([]byte, error)
+	fromDB  func([]byte) (interface{}, error)
+	sqlType SQLType
+}
+
+// RegisterConverter registers the conversions of a type which can't implement
+// Conversion, like net.IP. The fields of the type are mapped to sqlType,
+// toDB converts a value of the type to the data written to the database, and
+// fromDB converts the data read from the database to a value of the type.
+// The converters are the engine's, a type should be registered before the
+// structs which have fields of the type are mapped.
+func (engine *Engine) RegisterConverter(t reflect.Type, toDB func(interface{}) ([]byte, error),
+	fromDB func([]byte) (interface{}, error), sqlType SQLType) {
+	engine.convertersMutex.Lock()
+	defer engine.convertersMutex.Unlock()
+	if engine.converters == nil {
+		engine.converters = make(map[reflect.Type]*converter)
+	}
+	engine.converters[t] = &converter{toDB: toDB, fromDB: fromDB, sqlType: sqlType}
+}
+
+// the registered converter of a type
+func (engine *Engine) converterOf(t reflect.Type) (*converter, bool) {
+	engine.convertersMutex.RLock()
+	defer engine.convertersMutex.RUnlock()
+	c, ok := engine.converters[t]
+	return c, ok
+}
+
+// the sql type of a field type, the registered converter of the type or the
+// type it points to is used first
+func (engine *Engine) type2SQLType(t reflect.Type) SQLType {
+	for et := t; ; et = et.Elem() {
+		if c, ok := engine.converterOf(et); ok {
+			return c.sqlType
+		}
+		if et.Kind() != reflect.Ptr {
+			break
+		}
+	}
+	return Type2SQLType(t)
+}
+
+// convert a field value to the value written to the column, a nil value is
+// null, and the data is bytes for a binary column or a string for the others
+func (c *converter) value(col *Column, fieldValue reflect.Value) (interface{}, error) {
+	switch fieldValue.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		if fieldValue.IsNil() {
+			return nil, nil
+		}
+	}
+	data, err := c.toDB(fieldValue.Interface())
+	if err != nil {
+		return nil, err
+	}
+	if SQLType2Type(col.SQLType) == reflect.TypeOf([]byte{}) {
+		return data, nil
+	}
+	return string(data), nil
+}
+
+// set the value converted from the data to the field
+func (c *converter) set(fieldValue reflect.Value, data []byte) error {
+	v, err := c.fromDB(data)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		fieldValue.Set(reflect.Zero(fieldValue.Type()))
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Type().AssignableTo(fieldValue.Type()) {
+		fieldValue.Set(rv)
+		return nil
+	}
+	if rv.Type().ConvertibleTo(fieldValue.Type()) && rv.Kind() == fieldValue.Kind() {
+		fieldValue.Set(rv.Convert(fieldValue.Type()))
+		return nil
+	}
+	return errors.New("can't set " + rv.Type().String() + " to " + fieldValue.Type().String())
+}
@@ -1,6 +1,7 @@
 package xorm
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -31,6 +33,14 @@ type dialect interface {
 	IndexCheckSql(tableName, idxName string) (string, []interface{})
 	TableCheckSql(tableName string) (string, []interface{})
 	ColumnCheckSql(tableName, colName string) (string, []interface{})
+	ModifyColumnSql(tableName string, col *Column) ([]string, error)
+	SavepointSql(name string) (savepoint, release, rollbackTo string)
+	IsRetryableError(err error) bool
+	SetTransactionSql(level sql.IsolationLevel, readOnly bool) (beforeBegin, afterBegin, reset []string, err error)
+	UpsertSql(tableName string, conflictCols, updateCols []string, version string) string
+	MaxInsertArgs() int
+	SupportReturning() bool
+	ConsecutiveInsertIds() bool
 
 	GetColumns(tableName string) ([]string, map[string]*Column, error)
 	GetTables() ([]*Table, error)
@@ -56,6 +66,18 @@ type Engine struct {
 	Logger         io.Writer
 	Cacher         Cacher
 	UseCache       bool
+	QueryTimeout   time.Duration  // default timeout of one query when no context is given
+	TxRetries      int            // max retry times of Transaction when deadlock or serialization failure
+	TZLocation     *time.Location // time zone of the application, the times read are converted to it
+	DatabaseTZ     *time.Location // time zone of the times stored in the database
+	group          *EngineGroup
+	stmtCacheSize  int
+	stmtMutex      sync.Mutex
+	stmtCaches     map[*sql.DB]*stmtCache
+	stmtStats      *StmtCacheStats // allocated alone to be 64-bit aligned for the atomic operations
+
+	convertersMutex sync.RWMutex
+	converters      map[reflect.Type]*converter
 }
 
 // If engine's database support batch insert records like
@@ -121,6 +143,25 @@ func (engine *Engine) NoCache() *Session {
 	return session.NoCache()
 }
 
+// Shard splits the bean's table into shard tables by the rule, Insert, Get,
+// Find, Count, Iterate, Update and Delete are routed to the shard table of
+// the key, or run on all the shard tables when there is no key.
+func (engine *Engine) Shard(bean interface{}, rule ShardRule) {
+	t := rType(bean)
+	engine.autoMapType(t)
+	engine.Tables[t].shard = rule
+}
+
+// ShardTables returns the names of all the shard tables of the bean, they
+// could be created by engine.Table(name).CreateTable(bean)
+func (engine *Engine) ShardTables(bean interface{}) []string {
+	table := engine.autoMapType(rType(bean))
+	if table.shard == nil {
+		return nil
+	}
+	return table.shardTables()
+}
+
 // Set a table use a special cacher
 func (engine *Engine) MapCacher(bean interface{}, cacher Cacher) {
 	t := rType(bean)
@@ -142,6 +183,7 @@ func (engine *Engine) NewSession() *Session {
 
 // Close the engine
 func (engine *Engine) Close() error {
+	engine.closeStmtCaches()
 	return engine.Pool.Close(engine)
 }
 
@@ -181,6 +223,14 @@ func (engine *Engine) LogWarn(contents ...interface{}) {
 	}
 }
 
+// Context sets the context of the returned session, the operations will be
+// cancelled when the context is done.
+func (engine *Engine) Context(ctx context.Context) *Session {
+	session := engine.NewSession()
+	session.IsAutoClose = true
+	return session.Context(ctx)
+}
+
 // Sql method let's you manualy write raw sql and operate
 // For example:
 //
@@ -194,6 +244,13 @@ func (engine *Engine) Sql(querystring string, args ...interface{}) *Session {
 	return session.Sql(querystring, args...)
 }
 
+// ChunksInTx inserts all the chunks of a large slice in one transaction
+func (engine *Engine) ChunksInTx() *Session {
+	session := engine.NewSession()
+	session.IsAutoClose = true
+	return session.ChunksInTx()
+}
+
 // Default if your struct has "created" or "updated" filed tag, the fields
 // will automatically be filled with current time when Insert or Update
 // invoked. Call NoAutoTime if you dont' want to fill automatically.
@@ -211,30 +268,44 @@ func (engine *Engine) DBMetas() ([]*Table, error) {
 	}
 
 	for _, table := range tables {
-		colSeq, cols, err := engine.dialect.GetColumns(table.Name)
+		err = engine.loadTableMeta(table)
 		if err != nil {
 			return nil, err
 		}
-		table.Columns = cols
-		table.ColumnsSeq = colSeq
+	}
+	return tables, nil
+}
 
-		indexes, err := engine.dialect.GetIndexes(table.Name)
-		if err != nil {
-			return nil, err
+// retrieve the columns and indexes of a table from database
+func (engine *Engine) loadTableMeta(table *Table) error {
+	colSeq, cols, err := engine.dialect.GetColumns(table.Name)
+	if err != nil {
+		return err
+	}
+	table.Columns = cols
+	table.ColumnsSeq = colSeq
+	for _, name := range colSeq {
+		if cols[name].IsPrimaryKey {
+			table.PrimaryKeys = append(table.PrimaryKeys, name)
 		}
-		table.Indexes = indexes
+	}
 
-		for _, index := range indexes {
-			for _, name := range index.Cols {
-				if col, ok := table.Columns[name]; ok {
-					col.Indexes[index.Name] = true
-				} else {
-					return nil, errors.New("Unkonwn col " + name + " in indexes")
-				}
+	indexes, err := engine.dialect.GetIndexes(table.Name)
+	if err != nil {
+		return err
+	}
+	table.Indexes = indexes
+
+	for _, index := range indexes {
+		for _, name := range index.Cols {
+			if col, ok := table.Columns[name]; ok {
+				col.Indexes[index.Name] = true
+			} else {
+				return errors.New("Unkonwn col " + name + " in indexes")
 			}
 		}
 	}
-	return tables, nil
+	return nil
 }
 
 // use cascade or not
@@ -245,19 +316,27 @@ func (engine *Engine) Cascade(trueOrFalse ...bool) *Session {
 }
 
 // Where method provide a condition query
-func (engine *Engine) Where(querystring string, args ...interface{}) *Session {
+func (engine *Engine) Where(query interface{}, args ...interface{}) *Session {
 	session := engine.NewSession()
 	session.IsAutoClose = true
-	return session.Where(querystring, args...)
+	return session.Where(query, args...)
 }
 
-// Id mehtod provoide a condition as (id) = ?
-func (engine *Engine) Id(id int64) *Session {
+// Id mehtod provoide a condition as (id) = ?, id could be a PK for
+// composite primary keys
+func (engine *Engine) Id(id interface{}) *Session {
 	session := engine.NewSession()
 	session.IsAutoClose = true
 	return session.Id(id)
 }
 
+// ShardKey routes the operation to the shard table of the key
+func (engine *Engine) ShardKey(key interface{}) *Session {
+	session := engine.NewSession()
+	session.IsAutoClose = true
+	return session.ShardKey(key)
+}
+
 // set charset when create table, only support mysql now
 func (engine *Engine) Charset(charset string) *Session {
 	session := engine.NewSession()
@@ -313,11 +392,55 @@ func (engine *Engine) In(column string, args ...interface{}) *Session {
 	return session.In(column, args...)
 }
 
+// Transaction runs fun in a new transaction, see Session.Transaction
+func (engine *Engine) Transaction(fun func(*Session) error) error {
+	session := engine.NewSession()
+	defer session.Close()
+	return session.Transaction(fun)
+}
+
+// TransactionWith runs fun in a new transaction with the isolation level and
+// read only mode of opts
+func (engine *Engine) TransactionWith(opts *sql.TxOptions, fun func(*Session) error) error {
+	session := engine.NewSession()
+	defer session.Close()
+	return session.TransactionWith(opts, fun)
+}
+
+// Unscoped makes Get, Find, Count and Iterate include the soft deleted
+// records, and Delete delete the records from the table
+func (engine *Engine) Unscoped() *Session {
+	session := engine.NewSession()
+	session.IsAutoClose = true
+	return session.Unscoped()
+}
+
+// Before adds a closure called before every insert, update or delete
+func (engine *Engine) Before(closure func(interface{})) *Session {
+	session := engine.NewSession()
+	session.IsAutoClose = true
+	return session.Before(closure)
+}
+
+// After adds a closure called after every insert, update or delete
+func (engine *Engine) After(closure func(interface{})) *Session {
+	session := engine.NewSession()
+	session.IsAutoClose = true
+	return session.After(closure)
+}
+
+// Preload loads the relations after Find or Get
+func (engine *Engine) Preload(relations ...string) *Session {
+	session := engine.NewSession()
+	session.IsAutoClose = true
+	return session.Preload(relations...)
+}
+
 // Temporarily change the Get, Find, Update's table
-func (engine *Engine) Table(tableNameOrBean interface{}) *Session {
+func (engine *Engine) Table(tableNameOrBean interface{}, alias ...string) *Session {
 	session := engine.NewSession()
 	session.IsAutoClose = true
-	return session.Table(tableNameOrBean)
+	return session.Table(tableNameOrBean, alias...)
 }
 
 // This method will generate "LIMIT start, limit"
@@ -396,6 +519,8 @@ func (engine *Engine) newTable() *Table {
 	table.Indexes = make(map[string]*Index)
 	table.Columns = make(map[string]*Column)
 	table.ColumnsSeq = make([]string, 0)
+	table.PrimaryKeys = make([]string, 0)
+	table.relations = make(map[string]*relation)
 	table.Cacher = engine.Cacher
 	return table
 }
@@ -406,6 +531,7 @@ func (engine *Engine) mapType(t reflect.Type) *Table {
 	table.Type = t
 
 	var idFieldColName string
+	var idFieldType reflect.Type
 
 	for i := 0; i < t.NumField(); i++ {
 		tag := t.Field(i).Tag
@@ -422,16 +548,37 @@ func (engine *Engine) mapType(t reflect.Type) *Table {
 				if tags[0] == "-" {
 					continue
 				}
+				// a relation field is not a column, it's loaded by Preload
+				if rel := engine.parseRelation(t, t.Field(i), tags[0]); rel != nil {
+					table.relations[rel.fieldName] = rel
+					continue
+				}
 				if (strings.ToUpper(tags[0]) == "EXTENDS") &&
 					(fieldType.Kind() == reflect.Struct) {
 					parentTable := engine.mapType(fieldType)
-					for name, col := range parentTable.Columns {
-						col.FieldName = fmt.Sprintf("%v.%v", fieldType.Name(), col.FieldName)
-						table.Columns[name] = col
-						table.ColumnsSeq = append(table.ColumnsSeq, name)
+					fieldName := t.Field(i).Name
+					for _, name := range parentTable.ColumnsSeq {
+						col := parentTable.Columns[name]
+						col.FieldName = fmt.Sprintf("%v.%v", fieldName, col.FieldName)
+						// the same column of another extends struct is the
+						// first one's, and only the primary key of the first
+						// extends struct is the table's primary key
+						if _, ok := table.Columns[name]; ok {
+							continue
+						}
+						if col.IsPrimaryKey && len(table.PrimaryKeys) > 0 {
+							col.IsPrimaryKey = false
+							col.IsAutoIncrement = false
+						}
+						table.AddColumn(col)
 					}
-
-					table.PrimaryKey = parentTable.PrimaryKey
+					// the table name or alias of the struct when joining, it
+					// could be set by extends 'alias'
+					tableName := parentTable.Name
+					if len(tags) > 1 && strings.HasPrefix(tags[1], "'") && strings.HasSuffix(tags[1], "'") {
+						tableName = tags[1][1 : len(tags[1])-1]
+					}
+					table.extends = append(table.extends, &extendsTable{fieldName, tableName, parentTable})
 					continue
 				}
 				var indexType int
@@ -459,6 +606,10 @@ func (engine *Engine) mapType(t reflect.Type) *Table {
 						col.Default = "1"
 					case k == "UPDATED":
 						col.IsUpdated = true
+					case k == "DELETED":
+						// the records not deleted are null
+						col.IsDeleted = true
+						col.Nullable = true
 					case strings.HasPrefix(k, "INDEX(") && strings.HasSuffix(k, ")"):
 						indexType = IndexType
 						indexName = k[len("INDEX")+1 : len(k)-1]
@@ -501,7 +652,7 @@ func (engine *Engine) mapType(t reflect.Type) *Table {
 					}
 				}
 				if col.SQLType.Name == "" {
-					col.SQLType = Type2SQLType(fieldType)
+					col.SQLType = engine.type2SQLType(fieldType)
 				}
 				if col.Length == 0 {
 					col.Length = col.SQLType.DefaultLength
@@ -541,10 +692,10 @@ func (engine *Engine) mapType(t reflect.Type) *Table {
 				}
 			}
 		} else {
-			sqlType := Type2SQLType(fieldType)
+			sqlType := engine.type2SQLType(fieldType)
 			col = &Column{engine.Mapper.Obj2Table(t.Field(i).Name), t.Field(i).Name, sqlType,
 				sqlType.DefaultLength, sqlType.DefaultLength2, true, "", make(map[string]bool), false, false,
-				TWOSIDES, false, false, false, false}
+				TWOSIDES, false, false, false, false, false}
 		}
 		if col.IsAutoIncrement {
 			col.Nullable = false
@@ -554,15 +705,21 @@ func (engine *Engine) mapType(t reflect.Type) *Table {
 
 		if col.FieldName == "Id" || strings.HasSuffix(col.FieldName, ".Id") {
 			idFieldColName = col.Name
+			idFieldType = fieldType
 		}
 	}
 
-	if idFieldColName != "" && table.PrimaryKey == "" {
+	// a field named Id will be the primary key, and only an integer Id
+	// will be autoincrement
+	if idFieldColName != "" && len(table.PrimaryKeys) == 0 {
 		col := table.Columns[idFieldColName]
 		col.IsPrimaryKey = true
-		col.IsAutoIncrement = true
 		col.Nullable = false
-		table.PrimaryKey = col.Name
+		table.PrimaryKeys = append(table.PrimaryKeys, col.Name)
+		if isIntKind(idFieldType) {
+			col.IsAutoIncrement = true
+			table.AutoIncrement = col.Name
+		}
 	}
 
 	return table
@@ -619,16 +776,21 @@ func (engine *Engine) CreateUniques(bean interface{}) error {
 	return session.CreateUniques(bean)
 }
 
-// If enabled cache, clear the cache bean
-func (engine *Engine) ClearCacheBean(bean interface{}, id int64) error {
+// If enabled cache, clear the cache bean, id could be a PK for
+// composite primary keys
+func (engine *Engine) ClearCacheBean(bean interface{}, id interface{}) error {
 	t := rType(bean)
 	if t.Kind() != reflect.Struct {
 		return errors.New("error params")
 	}
 	table := engine.autoMap(bean)
 	if table.Cacher != nil {
+		sid, err := toPK(id).ToString()
+		if err != nil {
+			return err
+		}
 		table.Cacher.ClearIds(table.Name)
-		table.Cacher.DelBean(table.Name, id)
+		table.Cacher.DelBean(table.Name, sid)
 	}
 	return nil
 }
@@ -844,6 +1006,13 @@ func (engine *Engine) InsertOne(bean interface{}) (int64, error) {
 	return session.InsertOne(bean)
 }
 
+// Upsert inserts the record, or updates it when the conflict columns conflict
+func (engine *Engine) Upsert(bean interface{}, conflictCols ...string) (int64, error) {
+	session := engine.NewSession()
+	defer session.Close()
+	return session.Upsert(bean, conflictCols...)
+}
+
 // Update records, bean's non-empty fields are updated contents,
 // condiBean' non-empty filds are conditions
 // CAUTION:
@@ -12,4 +12,7 @@ var (
 	ErrCacheFailed     error = errors.New("Cache failed")
 	ErrNeedDeletedCond error = errors.New("Delete need at least one condition")
 	ErrNotImplemented  error = errors.New("Not implemented.")
+	ErrPrimaryKeyCount error = errors.New("Primary key values count does not match primary key columns")
+	ErrOptimisticLock  error = errors.New("The record has been updated or deleted by others")
+	ErrZeroVersion     error = errors.New("The version of the struct to update is zero")
 )
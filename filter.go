@@ -40,10 +40,11 @@ type IdFilter struct {
 }
 
 func (i *IdFilter) Do(sql string, session *Session) string {
-	if session.Statement.RefTable != nil && session.Statement.RefTable.PrimaryKey != "" {
-		sql = strings.Replace(sql, "`(id)`", session.Engine.Quote(session.Statement.RefTable.PrimaryKey), -1)
-		sql = strings.Replace(sql, session.Engine.Quote("(id)"), session.Engine.Quote(session.Statement.RefTable.PrimaryKey), -1)
-		return strings.Replace(sql, "(id)", session.Engine.Quote(session.Statement.RefTable.PrimaryKey), -1)
+	if session.Statement.RefTable != nil && len(session.Statement.RefTable.PrimaryKeys) == 1 {
+		pkName := session.Statement.RefTable.PrimaryKeys[0]
+		sql = strings.Replace(sql, "`(id)`", session.Engine.Quote(pkName), -1)
+		sql = strings.Replace(sql, session.Engine.Quote("(id)"), session.Engine.Quote(pkName), -1)
+		return strings.Replace(sql, "(id)", session.Engine.Quote(pkName), -1)
 	}
 	return sql
 }
@@ -0,0 +1,272 @@
+package xorm
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// GroupPolicy chooses a slave for a read of an engine group
+type GroupPolicy interface {
+	// Choose returns one of the indexes, the indexes are the positions of
+	// the healthy slaves in the group
+	Choose(indexes []int) int
+}
+
+type roundRobinPolicy struct {
+	mutex sync.Mutex
+	pos   int
+}
+
+// RoundRobinPolicy chooses the healthy slaves one by one
+func RoundRobinPolicy() GroupPolicy {
+	return &roundRobinPolicy{}
+}
+
+func (policy *roundRobinPolicy) Choose(indexes []int) int {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+	idx := indexes[policy.pos%len(indexes)]
+	policy.pos++
+	return idx
+}
+
+type randomPolicy struct {
+	mutex sync.Mutex
+	r     *rand.Rand
+}
+
+// RandomPolicy chooses a healthy slave randomly
+func RandomPolicy() GroupPolicy {
+	return &randomPolicy{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (policy *randomPolicy) Choose(indexes []int) int {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+	return indexes[policy.r.Intn(len(indexes))]
+}
+
+type weightRandomPolicy struct {
+	randomPolicy
+	weights []int
+}
+
+// WeightRandomPolicy chooses a healthy slave randomly by the weights, the
+// weights are in the same order as the slaves. A slave without weight or
+// with a negative weight is taken as weight 0, if all the healthy slaves'
+// weights are 0, one of them is chosen randomly.
+func WeightRandomPolicy(weights ...int) GroupPolicy {
+	policy := &weightRandomPolicy{weights: weights}
+	policy.r = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return policy
+}
+
+func (policy *weightRandomPolicy) weight(idx int) int {
+	if idx < len(policy.weights) && policy.weights[idx] > 0 {
+		return policy.weights[idx]
+	}
+	return 0
+}
+
+func (policy *weightRandomPolicy) Choose(indexes []int) int {
+	total := 0
+	for _, idx := range indexes {
+		total += policy.weight(idx)
+	}
+	if total == 0 {
+		return policy.randomPolicy.Choose(indexes)
+	}
+
+	policy.mutex.Lock()
+	n := policy.r.Intn(total)
+	policy.mutex.Unlock()
+	for _, idx := range indexes {
+		n -= policy.weight(idx)
+		if n < 0 {
+			return idx
+		}
+	}
+	return indexes[len(indexes)-1]
+}
+
+// EngineGroup is a master engine with a group of slave engines. Find, Get,
+// Count, Iterate and Query read from a slave, the others, including all the
+// operations in a transaction, are executed by the master. The master's
+// mapping, cacher, filters and dialect are used for the slaves, so the slaves
+// should be the same kind of database as the master.
+type EngineGroup struct {
+	*Engine
+	slaves []*Engine
+	policy GroupPolicy
+	mutex  sync.RWMutex
+	down   []bool
+	stop   chan bool
+}
+
+// NewEngineGroup creates a group of the master and the slaves, the default
+// policy is RoundRobinPolicy. If there is no healthy slave, the master is
+// read.
+func NewEngineGroup(master *Engine, slaves []*Engine, policy ...GroupPolicy) *EngineGroup {
+	group := &EngineGroup{
+		Engine: master,
+		slaves: slaves,
+		down:   make([]bool, len(slaves)),
+	}
+	if len(policy) > 0 && policy[0] != nil {
+		group.policy = policy[0]
+	} else {
+		group.policy = RoundRobinPolicy()
+	}
+	master.group = group
+	return group
+}
+
+// SetStmtCache sets the prepared statements cache of the master and all the
+// slaves
+func (group *EngineGroup) SetStmtCache(size int) {
+	group.Engine.SetStmtCache(size)
+	for _, slave := range group.slaves {
+		slave.SetStmtCache(size)
+	}
+}
+
+// StmtCacheStats returns the sum of the prepared statements cache counters
+// of the master and all the slaves
+func (group *EngineGroup) StmtCacheStats() StmtCacheStats {
+	stats := group.Engine.StmtCacheStats()
+	for _, slave := range group.slaves {
+		slaveStats := slave.StmtCacheStats()
+		stats.Hits += slaveStats.Hits
+		stats.Misses += slaveStats.Misses
+	}
+	return stats
+}
+
+// Master returns the master engine
+func (group *EngineGroup) Master() *Engine {
+	return group.Engine
+}
+
+// Slaves returns all the slave engines, including the down ones
+func (group *EngineGroup) Slaves() []*Engine {
+	return group.slaves
+}
+
+// SetPolicy changes the policy of choosing slaves
+func (group *EngineGroup) SetPolicy(policy GroupPolicy) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.policy = policy
+}
+
+// Slave returns a healthy slave chosen by the policy, nil is returned if
+// there is no healthy slave
+func (group *EngineGroup) Slave() *Engine {
+	group.mutex.RLock()
+	defer group.mutex.RUnlock()
+	indexes := make([]int, 0, len(group.slaves))
+	for i := range group.slaves {
+		if !group.down[i] {
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+	return group.slaves[group.policy.Choose(indexes)]
+}
+
+// ForceMaster returns a session which reads from the master
+func (group *EngineGroup) ForceMaster() *Session {
+	session := group.NewSession()
+	session.IsAutoClose = true
+	return session.ForceMaster()
+}
+
+// CheckHealth pings all the slaves, a slave is marked down if the ping
+// failed, and it's marked up again when a later ping succeeds
+func (group *EngineGroup) CheckHealth() {
+	for i, slave := range group.slaves {
+		err := slave.Ping()
+		if err != nil {
+			group.Engine.LogError("slave", i, "is down:", err)
+		}
+		group.mutex.Lock()
+		group.down[i] = err != nil
+		group.mutex.Unlock()
+	}
+}
+
+// StartHealthCheck checks the health of the slaves every interval in a
+// goroutine until StopHealthCheck or Close is called
+func (group *EngineGroup) StartHealthCheck(interval time.Duration) {
+	group.StopHealthCheck()
+	stop := make(chan bool)
+	group.mutex.Lock()
+	group.stop = stop
+	group.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				group.CheckHealth()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopHealthCheck stops the health check started by StartHealthCheck
+func (group *EngineGroup) StopHealthCheck() {
+	group.mutex.Lock()
+	stop := group.stop
+	group.stop = nil
+	group.mutex.Unlock()
+	// the checking goroutine may be waiting for the mutex in CheckHealth
+	if stop != nil {
+		stop <- true
+	}
+}
+
+// Close stops the health check and closes the master and all the slaves
+func (group *EngineGroup) Close() error {
+	group.StopHealthCheck()
+	err := group.Engine.Close()
+	for _, slave := range group.slaves {
+		if e := slave.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// read from a slave if the session belongs to an engine group and is not in
+// a transaction, the returned function releases the slave's db
+func (session *Session) useSlave() func() {
+	group := session.Engine.group
+	if group == nil || session.forceMaster || session.slaveDb != nil ||
+		(!session.IsAutoCommit && !session.IsCommitedOrRollbacked) {
+		return func() {}
+	}
+	slave := group.Slave()
+	if slave == nil {
+		return func() {}
+	}
+	db, err := slave.Pool.RetrieveDB(slave)
+	if err != nil {
+		session.Engine.LogWarn("retrieve slave db failed, read from master:", err)
+		return func() {}
+	}
+	session.slaveDb = db
+	session.slave = slave
+	return func() {
+		session.slaveDb = nil
+		session.slave = nil
+		slave.Pool.ReleaseDB(slave, db)
+	}
+}
@@ -1,8 +1,11 @@
 package xorm
 
 import (
+	"database/sql"
+	"errors"
 	"reflect"
 	"strings"
+	"time"
 )
 
 func indexNoCase(s, sep string) int {
@@ -45,6 +48,82 @@ func structName(v reflect.Type) string {
 	return v.Name()
 }
 
+// check if a field value is the zero value of its kind
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.String:
+		return v.String() == ""
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return v.IsNil()
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return t.IsZero()
+		}
+	}
+	return false
+}
+
+// set an autoincrement id to an int or uint kind field
+func setIntId(v reflect.Value, id int64) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(id)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(id))
+	}
+}
+
+// set a value which is written to the database back to a field, a nil value
+// is a nil pointer or a null Scanner
+func setFieldValue(v reflect.Value, value interface{}) error {
+	if scanner, ok := v.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(value)
+	}
+	if value == nil {
+		if v.Kind() != reflect.Ptr {
+			return errors.New("can't set null to " + v.Type().String())
+		}
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		x := reflect.New(v.Type().Elem())
+		if err := setFieldValue(x.Elem(), value); err != nil {
+			return err
+		}
+		v.Set(x)
+		return nil
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Type().AssignableTo(v.Type()) {
+		v.Set(rv)
+		return nil
+	}
+	if rv.Type().ConvertibleTo(v.Type()) && rv.Kind() == v.Kind() {
+		v.Set(rv.Convert(v.Type()))
+		return nil
+	}
+	return errors.New("can't set " + rv.Type().String() + " to " + v.Type().String())
+}
+
+// check if a type is an int or uint kind
+func isIntKind(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
 func sliceEq(left, right []string) bool {
 	for _, l := range left {
 		var find bool
@@ -61,3 +140,31 @@ func sliceEq(left, right []string) bool {
 
 	return true
 }
+
+// get an exported field of a driver's error, for example the Number of
+// mysql's error, so the drivers needn't be imported
+func errField(err error, name string) (reflect.Value, bool) {
+	v := reflect.Indirect(reflect.ValueOf(err))
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	field := v.FieldByName(name)
+	return field, field.IsValid()
+}
+
+// generate the characteristics of "SET TRANSACTION", like
+// "ISOLATION LEVEL SERIALIZABLE, READ ONLY"
+func transactionCharacteristics(level sql.IsolationLevel, readOnly bool) (string, error) {
+	chars := make([]string, 0, 2)
+	switch level {
+	case sql.LevelDefault:
+	case sql.LevelReadUncommitted, sql.LevelReadCommitted, sql.LevelRepeatableRead, sql.LevelSerializable:
+		chars = append(chars, "ISOLATION LEVEL "+strings.ToUpper(level.String()))
+	default:
+		return "", errors.New("unsupported isolation level " + level.String())
+	}
+	if readOnly {
+		chars = append(chars, "READ ONLY")
+	}
+	return strings.Join(chars, ", "), nil
+}
@@ -0,0 +1,150 @@
+package xorm
+
+import (
+	"reflect"
+)
+
+// BeforeInsertProcessor is called before the bean is inserted
+type BeforeInsertProcessor interface {
+	BeforeInsert()
+}
+
+// AfterInsertProcessor is called after the bean is inserted
+type AfterInsertProcessor interface {
+	AfterInsert()
+}
+
+// BeforeUpdateProcessor is called before the records are updated by the bean
+type BeforeUpdateProcessor interface {
+	BeforeUpdate()
+}
+
+// AfterUpdateProcessor is called after the records are updated by the bean
+type AfterUpdateProcessor interface {
+	AfterUpdate()
+}
+
+// BeforeDeleteProcessor is called before the records are deleted by the bean
+type BeforeDeleteProcessor interface {
+	BeforeDelete()
+}
+
+// AfterDeleteProcessor is called after the records are deleted by the bean
+type AfterDeleteProcessor interface {
+	AfterDelete()
+}
+
+// AfterLoadProcessor is called after the bean is loaded by Get, Find or
+// Iterate, the session uses the same context and transaction as the loading
+// session and could load more data.
+type AfterLoadProcessor interface {
+	AfterLoad(*Session)
+}
+
+const (
+	hookInsert = iota
+	hookUpdate
+	hookDelete
+)
+
+// Before adds a closure which is called with the bean before every insert,
+// update or delete of this session
+func (session *Session) Before(closure func(interface{})) *Session {
+	session.beforeClosures = append(session.beforeClosures, closure)
+	return session
+}
+
+// After adds a closure which is called with the bean after every successful
+// insert, update or delete of this session
+func (session *Session) After(closure func(interface{})) *Session {
+	session.afterClosures = append(session.afterClosures, closure)
+	return session
+}
+
+func (session *Session) before(hook int, bean interface{}) {
+	for _, closure := range session.beforeClosures {
+		closure(bean)
+	}
+	switch hook {
+	case hookInsert:
+		if processor, ok := bean.(BeforeInsertProcessor); ok {
+			processor.BeforeInsert()
+		}
+	case hookUpdate:
+		if processor, ok := bean.(BeforeUpdateProcessor); ok {
+			processor.BeforeUpdate()
+		}
+	case hookDelete:
+		if processor, ok := bean.(BeforeDeleteProcessor); ok {
+			processor.BeforeDelete()
+		}
+	}
+}
+
+// the after closures should be kept before the operation, the session may be
+// reset when it's auto closed
+func after(closures []func(interface{}), hook int, bean interface{}) {
+	for _, closure := range closures {
+		closure(bean)
+	}
+	switch hook {
+	case hookInsert:
+		if processor, ok := bean.(AfterInsertProcessor); ok {
+			processor.AfterInsert()
+		}
+	case hookUpdate:
+		if processor, ok := bean.(AfterUpdateProcessor); ok {
+			processor.AfterUpdate()
+		}
+	case hookDelete:
+		if processor, ok := bean.(AfterDeleteProcessor); ok {
+			processor.AfterDelete()
+		}
+	}
+}
+
+// the beans of a slice, the addresses of the struct elements are returned
+func sliceBeans(sliceValue reflect.Value) []interface{} {
+	beans := make([]interface{}, sliceValue.Len())
+	for i := range beans {
+		elem := sliceValue.Index(i)
+		if elem.Kind() == reflect.Ptr {
+			beans[i] = elem.Interface()
+		} else {
+			beans[i] = elem.Addr().Interface()
+		}
+	}
+	return beans
+}
+
+// if the pointers to the elements of the slice or map implement
+// AfterLoadProcessor
+func isLoader(sliceType reflect.Type) bool {
+	t := sliceType.Elem()
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PtrTo(t)
+	}
+	return t.Implements(reflect.TypeOf((*AfterLoadProcessor)(nil)).Elem())
+}
+
+// call AfterLoad of every loaded bean in the slice or map
+func afterLoad(rel *Session, sliceValue reflect.Value) {
+	if sliceValue.Kind() == reflect.Slice {
+		for _, bean := range sliceBeans(sliceValue) {
+			bean.(AfterLoadProcessor).AfterLoad(rel)
+		}
+		return
+	}
+	for _, key := range sliceValue.MapKeys() {
+		elem := sliceValue.MapIndex(key)
+		if elem.Kind() == reflect.Ptr {
+			elem.Interface().(AfterLoadProcessor).AfterLoad(rel)
+			continue
+		}
+		// the map values are not addressable
+		bean := reflect.New(elem.Type())
+		bean.Elem().Set(elem)
+		bean.Interface().(AfterLoadProcessor).AfterLoad(rel)
+		sliceValue.SetMapIndex(key, bean.Elem())
+	}
+}
@@ -0,0 +1,453 @@
+package xorm
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration is one versioned step of the schema. Up and Down are Go funcs
+// which run in a transaction; if they are nil, UpSql and DownSql will be
+// executed instead, both of them could contain more than one sql statement
+// separated by ";".
+//
+// Notice: mysql will commit the transaction implicitly when executing DDL,
+// so a failed migration may be applied partly on mysql.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(*Session) error
+	Down    func(*Session) error
+	UpSql   string
+	DownSql string
+}
+
+// the status of one migration
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// the record of an applied migration in the version table
+type migrationRecord struct {
+	Version   int64     `xorm:"'version' pk"`
+	Name      string    `xorm:"'name' varchar(255)"`
+	AppliedAt time.Time `xorm:"'applied_at'"`
+}
+
+// Migrator runs the migrations and keeps the applied versions in the
+// table TableName, the default is xorm_migration.
+type Migrator struct {
+	Engine     *Engine
+	TableName  string
+	migrations []*Migration
+}
+
+type migrationSorter []*Migration
+
+func (s migrationSorter) Len() int           { return len(s) }
+func (s migrationSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s migrationSorter) Less(i, j int) bool { return s[i].Version < s[j].Version }
+
+// new a migrator with migrations
+func NewMigrator(engine *Engine, migrations ...*Migration) *Migrator {
+	m := &Migrator{Engine: engine, TableName: "xorm_migration"}
+	m.migrations = append(m.migrations, migrations...)
+	sort.Sort(migrationSorter(m.migrations))
+	return m
+}
+
+// Add more migrations, the migrations will be sorted by version
+func (m *Migrator) Add(migrations ...*Migration) {
+	m.migrations = append(m.migrations, migrations...)
+	sort.Sort(migrationSorter(m.migrations))
+}
+
+func (m *Migrator) check() error {
+	for i, migration := range m.migrations {
+		if migration.Version <= 0 {
+			return errors.New(fmt.Sprintf("migration %v has an invalid version %v", migration.Name, migration.Version))
+		}
+		if i > 0 && m.migrations[i-1].Version == migration.Version {
+			return errors.New(fmt.Sprintf("migration version %v is duplicated", migration.Version))
+		}
+		if !hasSteps(migration.Up, migration.UpSql) {
+			return errors.New(fmt.Sprintf("migration %v has no up func or sql", migration.Version))
+		}
+	}
+	return nil
+}
+
+// check if a migration's up or down has a func or sql statements
+func hasSteps(fun func(*Session) error, sqls string) bool {
+	return fun != nil || len(splitSqls(sqls)) > 0
+}
+
+// check if the migrations could be rollbacked before executing anything
+func checkDowns(downs []*Migration) error {
+	for _, migration := range downs {
+		if !hasSteps(migration.Down, migration.DownSql) {
+			return errors.New(fmt.Sprintf("migration %v has no down func or sql", migration.Version))
+		}
+	}
+	return nil
+}
+
+// create the version table if it's not exist and return all applied records
+func (m *Migrator) applied() (map[int64]*migrationRecord, error) {
+	err := m.Engine.Table(m.TableName).CreateTable(new(migrationRecord))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]migrationRecord, 0)
+	err = m.Engine.Table(m.TableName).NoCache().Find(&records)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]*migrationRecord)
+	for i, record := range records {
+		applied[record.Version] = &records[i]
+	}
+	return applied, nil
+}
+
+// Status returns all the migrations and applied versions which have no
+// migration, ordered by version.
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]*MigrationStatus, 0)
+	for _, migration := range m.migrations {
+		s := &MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = record.AppliedAt
+			delete(applied, migration.Version)
+		}
+		status = append(status, s)
+	}
+	for _, record := range applied {
+		status = append(status, &MigrationStatus{Version: record.Version,
+			Name: record.Name, Applied: true, AppliedAt: record.AppliedAt})
+	}
+	sort.Sort(statusSorter(status))
+	return status, nil
+}
+
+type statusSorter []*MigrationStatus
+
+func (s statusSorter) Len() int           { return len(s) }
+func (s statusSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s statusSorter) Less(i, j int) bool { return s[i].Version < s[j].Version }
+
+// Version returns the max applied version, 0 if no migration applied
+func (m *Migrator) Version() (int64, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// Migrate applies all the pending migrations
+func (m *Migrator) Migrate() error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+	return m.MigrateTo(m.migrations[len(m.migrations)-1].Version)
+}
+
+// MigrateTo applies the pending migrations whose version is not greater than
+// version, and rollbacks the applied migrations whose version is greater than
+// version. So MigrateTo(0) will rollback all.
+func (m *Migrator) MigrateTo(version int64) error {
+	err := m.check()
+	if err != nil {
+		return err
+	}
+	err = m.rollbackTo(version)
+	if err != nil {
+		return err
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			err = m.up(migration)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RollbackTo rollbacks the applied migrations whose versions are greater
+// than version, the pending migrations are not applied
+func (m *Migrator) RollbackTo(version int64) error {
+	err := m.check()
+	if err != nil {
+		return err
+	}
+	return m.rollbackTo(version)
+}
+
+// rollback the applied migrations whose versions are greater than version
+func (m *Migrator) rollbackTo(version int64) error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	// all the applied versions to rollback should be found at first
+	downs := make([]*Migration, 0)
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; ok && migration.Version > version {
+			downs = append(downs, migration)
+		}
+		delete(applied, migration.Version)
+	}
+	for v := range applied {
+		if v > version {
+			return errors.New(fmt.Sprintf("applied migration version %v is not found", v))
+		}
+	}
+	err = checkDowns(downs)
+	if err != nil {
+		return err
+	}
+	for _, migration := range downs {
+		err = m.down(migration)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback rollbacks the last applied migration
+func (m *Migrator) Rollback() error {
+	err := m.check()
+	if err != nil {
+		return err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	var version int64
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	if version == 0 {
+		return nil
+	}
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			err = checkDowns([]*Migration{migration})
+			if err != nil {
+				return err
+			}
+			return m.down(migration)
+		}
+	}
+	return errors.New(fmt.Sprintf("applied migration version %v is not found", version))
+}
+
+func (m *Migrator) up(migration *Migration) error {
+	m.Engine.LogDebug("[migrate] up", migration.Version, migration.Name)
+	return m.run(func(session *Session) error {
+		var err error
+		if migration.Up != nil {
+			err = migration.Up(session)
+		} else {
+			err = execSqls(session, migration.UpSql)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = session.Table(m.TableName).NoCache().Insert(&migrationRecord{
+			Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()})
+		return err
+	})
+}
+
+func (m *Migrator) down(migration *Migration) error {
+	m.Engine.LogDebug("[migrate] down", migration.Version, migration.Name)
+	return m.run(func(session *Session) error {
+		var err error
+		if migration.Down != nil {
+			err = migration.Down(session)
+		} else {
+			err = execSqls(session, migration.DownSql)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = session.Table(m.TableName).NoCache().Id(migration.Version).Delete(new(migrationRecord))
+		return err
+	})
+}
+
+// run fun in a transaction
+func (m *Migrator) run(fun func(*Session) error) error {
+	session := m.Engine.NewSession()
+	defer session.Close()
+
+	err := session.Begin()
+	if err != nil {
+		return err
+	}
+	err = fun(session)
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+	return session.Commit()
+}
+
+func execSqls(session *Session, content string) error {
+	for _, sql := range splitSqls(content) {
+		_, err := session.Exec(sql)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// split the content to sql statements by ";", the ";" in quotes and the
+// comments starting with "--" are skipped.
+func splitSqls(content string) []string {
+	sqls := make([]string, 0)
+	var quote rune
+	var comment bool
+	start := 0
+	runes := []rune(content)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case comment:
+			if c == '\n' {
+				comment = false
+			}
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			comment = true
+		case c == ';':
+			if sql := strings.TrimSpace(string(runes[start:i])); sql != "" {
+				sqls = append(sqls, sql)
+			}
+			start = i + 1
+		}
+	}
+	if sql := strings.TrimSpace(string(runes[start:])); sql != "" {
+		sqls = append(sqls, sql)
+	}
+
+	// remove the statements which only have comments
+	res := make([]string, 0)
+	for _, sql := range sqls {
+		for _, line := range strings.Split(sql, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "--") {
+				res = append(res, sql)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// LoadMigrations loads sql migrations from a directory. The file names should
+// be like 20140101_create_user.up.sql and 20140101_create_user.down.sql, the
+// number before "_" is the version and the rest is the name.
+func LoadMigrations(dir string) ([]*Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make(map[int64]*Migration)
+	for _, file := range files {
+		fileName := file.Name()
+		if file.IsDir() || !strings.HasSuffix(fileName, ".sql") {
+			continue
+		}
+
+		var isUp bool
+		var base string
+		if strings.HasSuffix(fileName, ".up.sql") {
+			isUp = true
+			base = fileName[:len(fileName)-len(".up.sql")]
+		} else if strings.HasSuffix(fileName, ".down.sql") {
+			base = fileName[:len(fileName)-len(".down.sql")]
+		} else {
+			continue
+		}
+
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, errors.New("migration file " + fileName + " has no version: " + err.Error())
+		}
+		var name string
+		if len(parts) == 2 {
+			name = parts[1]
+		}
+
+		bs, err := ioutil.ReadFile(filepath.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := migrations[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			migrations[version] = migration
+		} else if migration.Name != name {
+			return nil, errors.New(fmt.Sprintf("migration version %v is duplicated", version))
+		}
+		if isUp {
+			migration.UpSql = string(bs)
+		} else {
+			migration.DownSql = string(bs)
+		}
+	}
+
+	res := make([]*Migration, 0, len(migrations))
+	for _, migration := range migrations {
+		res = append(res, migration)
+	}
+	sort.Sort(migrationSorter(res))
+	return res, nil
+}
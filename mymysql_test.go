@@ -66,3 +66,23 @@ func BenchmarkMyMysqlCache(t *testing.B) {
 	engine.SetDefaultCacher(NewLRUCacher(NewMemoryStore(), 1000))
 	doBenchCacheFind(engine, t)
 }
+
+func BenchmarkMyMysqlFind(t *testing.B) {
+	engine, err := NewEngine("mymysql", "xorm_test2/root/")
+	defer engine.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	doBenchFind(engine, t)
+}
+
+func BenchmarkMyMysqlFindByMap(t *testing.B) {
+	engine, err := NewEngine("mymysql", "xorm_test2/root/")
+	defer engine.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	doBenchFindByMap(engine, t)
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -128,6 +129,67 @@ func (db *mysql) IndexOnTable() bool {
 	return true
 }
 
+func (db *mysql) ModifyColumnSql(tableName string, col *Column) ([]string, error) {
+	sql := fmt.Sprintf("ALTER TABLE %v%v%v MODIFY COLUMN %v;", db.QuoteStr(), tableName,
+		db.QuoteStr(), strings.TrimSpace(col.StringNoPk(db)))
+	return []string{sql}, nil
+}
+
+func (db *mysql) SavepointSql(name string) (string, string, string) {
+	return "SAVEPOINT " + name, "RELEASE SAVEPOINT " + name, "ROLLBACK TO SAVEPOINT " + name
+}
+
+// mysql sets the characteristics of the next transaction, so it's executed
+// before the transaction begins
+func (db *mysql) SetTransactionSql(level sql.IsolationLevel, readOnly bool) ([]string, []string, []string, error) {
+	chars, err := transactionCharacteristics(level, readOnly)
+	if err != nil || chars == "" {
+		return nil, nil, nil, err
+	}
+	return []string{"SET TRANSACTION " + chars}, nil, nil, nil
+}
+
+// mysql updates the record when any primary key or unique index conflicts,
+// so the conflict columns are only used when there is nothing to update
+func (db *mysql) UpsertSql(tableName string, conflictCols, updateCols []string, version string) string {
+	sets := upsertSets(db.QuoteStr(), tableName, updateCols, version, func(col string) string {
+		return "VALUES(" + col + ")"
+	})
+	if len(sets) == 0 {
+		col := db.QuoteStr() + conflictCols[0] + db.QuoteStr()
+		sets = []string{col + " = " + col}
+	}
+	return " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+// the placeholders of a prepared statement are at most 65535
+func (db *mysql) MaxInsertArgs() int {
+	return 65535
+}
+
+func (db *mysql) SupportReturning() bool {
+	return false
+}
+
+// LastInsertId is the first id of the inserted records, and the ids are
+// consecutive when auto_increment_increment is 1
+func (db *mysql) ConsecutiveInsertIds() bool {
+	return true
+}
+
+// 1213 is deadlock and 1205 is lock wait timeout
+func (db *mysql) IsRetryableError(err error) bool {
+	if field, ok := errField(err, "Number"); ok {
+		switch field.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return field.Uint() == 1213 || field.Uint() == 1205
+		}
+	}
+	// mymysql's error is like "Received #1213 error from MySQL server: ..."
+	s := err.Error()
+	return strings.Contains(s, "#1213 ") || strings.Contains(s, "#1205 ")
+}
+
 func (db *mysql) IndexCheckSql(tableName, idxName string) (string, []interface{}) {
 	args := []interface{}{db.dbname, tableName, idxName}
 	sql := "SELECT `INDEX_NAME` FROM `INFORMATION_SCHEMA`.`STATISTICS`"
@@ -64,3 +64,23 @@ func BenchmarkMysqlCache(t *testing.B) {
 	engine.SetDefaultCacher(NewLRUCacher(NewMemoryStore(), 1000))
 	doBenchCacheFind(engine, t)
 }
+
+func BenchmarkMysqlFind(t *testing.B) {
+	engine, err := NewEngine("mysql", "root:@/xorm_test?charset=utf8")
+	defer engine.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	doBenchFind(engine, t)
+}
+
+func BenchmarkMysqlFindByMap(t *testing.B) {
+	engine, err := NewEngine("mysql", "root:@/xorm_test?charset=utf8")
+	defer engine.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	doBenchFindByMap(engine, t)
+}
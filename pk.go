@@ -0,0 +1,118 @@
+package xorm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// PK is a primary key value, one element for each primary key column.
+// For example:
+//
+//		engine.Id(xorm.PK{1, 2}).Get(&userGroup)
+//
+// will generate "WHERE user_id = 1 AND group_id = 2"
+type PK []interface{}
+
+// new a PK
+func NewPK(pks ...interface{}) *PK {
+	p := PK(pks)
+	return &p
+}
+
+// ToString encodes the PK as a string, it is used as a cache key
+func (p *PK) ToString() (string, error) {
+	bs, err := json.Marshal(*p)
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+// FromString decodes a PK which is encoded by ToString
+func (p *PK) FromString(content string) error {
+	dec := json.NewDecoder(bytes.NewBufferString(content))
+	dec.UseNumber()
+	return dec.Decode(p)
+}
+
+// convert an Id parameter to a PK, id could be a single value, a PK or a *PK
+func toPK(id interface{}) *PK {
+	switch v := id.(type) {
+	case PK:
+		return &v
+	case *PK:
+		return v
+	default:
+		return NewPK(id)
+	}
+}
+
+// convert a PK to the key of a map which is used by Find. A single primary
+// key is converted to the map's key type. For composite primary keys, the
+// key type could be a struct whose fields are in the same order as the
+// primary key columns, or a string which is the PK encoded by ToString.
+func pk2MapKey(pk PK, keyType reflect.Type) (reflect.Value, error) {
+	if len(pk) == 1 {
+		return convertKey(pk[0], keyType)
+	}
+
+	switch keyType.Kind() {
+	case reflect.Struct:
+		if keyType.NumField() != len(pk) {
+			return reflect.Value{}, ErrPrimaryKeyCount
+		}
+		key := reflect.New(keyType).Elem()
+		for i, v := range pk {
+			fieldValue, err := convertKey(v, keyType.Field(i).Type)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			key.Field(i).Set(fieldValue)
+		}
+		return key, nil
+	case reflect.String:
+		s, err := pk.ToString()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(s).Convert(keyType), nil
+	}
+	return reflect.Value{}, errors.New("unsupported map key type " + keyType.String())
+}
+
+// convert one primary key value to type t
+func convertKey(v interface{}, t reflect.Type) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Type() == t {
+		return rv, nil
+	}
+
+	s := fmt.Sprintf("%v", v)
+	key := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return reflect.Value{}, errors.New("pk " + s + " as int: " + err.Error())
+		}
+		key.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return reflect.Value{}, errors.New("pk " + s + " as uint: " + err.Error())
+		}
+		key.SetUint(x)
+	case reflect.String:
+		key.SetString(s)
+	default:
+		if rv.Type().ConvertibleTo(t) {
+			return rv.Convert(t), nil
+		}
+		return reflect.Value{}, errors.New("unsupported map key type " + t.String())
+	}
+	return key, nil
+}
@@ -50,6 +50,7 @@ func (p *NoneConnectPool) RetrieveDB(engine *Engine) (db *sql.DB, err error) {
 
 // ReleaseDB directly close a connection
 func (p *NoneConnectPool) ReleaseDB(engine *Engine, db *sql.DB) {
+	engine.closeStmtCache(db)
 	db.Close()
 }
 
@@ -247,6 +248,7 @@ func (p *SimpleConnectPool) ReleaseDB(engine *Engine, db *sql.DB) {
 	defer p.mutex.Unlock()
 	//fmt.Printf("%x, lbegin - released:%v, using:%v\n", &p, p.cur+1, len(p.usingConnects))
 	if p.cur >= p.maxIdleConns-1 {
+		engine.closeStmtCache(db)
 		db.Close()
 	} else {
 		p.cur = p.cur + 1
@@ -261,6 +263,7 @@ func (p *SimpleConnectPool) Close(engine *Engine) error {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 	for len(p.releasedConnects) > 0 {
+		engine.closeStmtCache(p.releasedConnects[0])
 		p.releasedConnects[0].Close()
 		p.releasedConnects = p.releasedConnects[1:]
 	}
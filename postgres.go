@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 )
@@ -121,6 +122,63 @@ func (db *postgres) IndexOnTable() bool {
 	return false
 }
 
+func (db *postgres) SavepointSql(name string) (string, string, string) {
+	return "SAVEPOINT " + name, "RELEASE SAVEPOINT " + name, "ROLLBACK TO SAVEPOINT " + name
+}
+
+// postgres sets the characteristics of the current transaction, so it should
+// be the first statement in the transaction
+func (db *postgres) SetTransactionSql(level sql.IsolationLevel, readOnly bool) ([]string, []string, []string, error) {
+	chars, err := transactionCharacteristics(level, readOnly)
+	if err != nil || chars == "" {
+		return nil, nil, nil, err
+	}
+	return nil, []string{"SET TRANSACTION " + chars}, nil, nil
+}
+
+func (db *postgres) UpsertSql(tableName string, conflictCols, updateCols []string, version string) string {
+	return onConflictSql(db.QuoteStr(), tableName, conflictCols, updateCols, version, "EXCLUDED")
+}
+
+// the parameters of a statement are at most 65535
+func (db *postgres) MaxInsertArgs() int {
+	return 65535
+}
+
+func (db *postgres) SupportReturning() bool {
+	return true
+}
+
+func (db *postgres) ConsecutiveInsertIds() bool {
+	return false
+}
+
+// 40001 is serialization failure and 40P01 is deadlock
+func (db *postgres) IsRetryableError(err error) bool {
+	if field, ok := errField(err, "Code"); ok && field.Kind() == reflect.String {
+		return field.String() == "40001" || field.String() == "40P01"
+	}
+	s := err.Error()
+	return strings.Contains(s, "deadlock detected") || strings.Contains(s, "could not serialize access")
+}
+
+func (db *postgres) ModifyColumnSql(tableName string, col *Column) ([]string, error) {
+	prefix := fmt.Sprintf("ALTER TABLE %v%v%v ALTER COLUMN %v%v%v ", db.QuoteStr(), tableName,
+		db.QuoteStr(), db.QuoteStr(), col.Name, db.QuoteStr())
+	sqls := []string{prefix + "TYPE " + db.SqlType(col) + ";"}
+	if col.Nullable {
+		sqls = append(sqls, prefix+"DROP NOT NULL;")
+	} else {
+		sqls = append(sqls, prefix+"SET NOT NULL;")
+	}
+	if col.Default != "" {
+		sqls = append(sqls, prefix+"SET DEFAULT "+col.Default+";")
+	} else {
+		sqls = append(sqls, prefix+"DROP DEFAULT;")
+	}
+	return sqls, nil
+}
+
 func (db *postgres) IndexCheckSql(tableName, idxName string) (string, []interface{}) {
 	args := []interface{}{tableName, idxName}
 	return `SELECT indexname FROM pg_indexes ` +
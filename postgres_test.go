@@ -160,3 +160,25 @@ func BenchmarkPostgresCache(t *testing.B) {
 	engine.SetDefaultCacher(NewLRUCacher(NewMemoryStore(), 1000))
 	doBenchCacheFind(engine, t)
 }
+
+func BenchmarkPostgresFind(t *testing.B) {
+	engine, err := NewEngine("postgres", "dbname=xorm_test sslmode=disable")
+
+	defer engine.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	doBenchFind(engine, t)
+}
+
+func BenchmarkPostgresFindByMap(t *testing.B) {
+	engine, err := NewEngine("postgres", "dbname=xorm_test sslmode=disable")
+
+	defer engine.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	doBenchFindByMap(engine, t)
+}
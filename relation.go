@@ -0,0 +1,354 @@
+package xorm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	belongsTo = iota + 1
+	hasOne
+	hasMany
+	manyToMany
+)
+
+// a relation field of a struct, it's not a column and could be loaded by
+// Preload. The field could be T, *T, []T or []*T.
+//
+//	belongs_to(fk)                    fk is a column of this table referencing the related's primary key
+//	has_one(fk) or has_many(fk)       fk is a column of the related table referencing this primary key
+//	many_to_many(table,fk,relatedFk)  table is the join table, fk references this primary key
+//	                                  and relatedFk references the related's primary key
+type relation struct {
+	fieldName string
+	kind      int
+	relType   reflect.Type
+	fk        string
+	joinTable string
+	joinFk    string
+}
+
+// parse a relation tag, nil is returned if the tag is not a relation. The
+// arguments could be omitted, the default foreign keys are like user_id.
+func (engine *Engine) parseRelation(t reflect.Type, field reflect.StructField, tag string) *relation {
+	k := strings.ToUpper(tag)
+	var kind int
+	switch {
+	case strings.HasPrefix(k, "BELONGS_TO"):
+		kind = belongsTo
+	case strings.HasPrefix(k, "HAS_ONE"):
+		kind = hasOne
+	case strings.HasPrefix(k, "HAS_MANY"):
+		kind = hasMany
+	case strings.HasPrefix(k, "MANY_TO_MANY"):
+		kind = manyToMany
+	default:
+		return nil
+	}
+
+	var args []string
+	if idx := strings.Index(tag, "("); idx > 0 && strings.HasSuffix(tag, ")") {
+		for _, arg := range strings.Split(tag[idx+1:len(tag)-1], ",") {
+			args = append(args, strings.TrimSpace(arg))
+		}
+	} else if strings.Contains(tag, "(") {
+		return nil
+	}
+
+	relType := field.Type
+	for relType.Kind() == reflect.Slice || relType.Kind() == reflect.Ptr {
+		relType = relType.Elem()
+	}
+	if relType.Kind() != reflect.Struct {
+		return nil
+	}
+
+	rel := &relation{fieldName: field.Name, kind: kind, relType: relType}
+	tableName := engine.Mapper.Obj2Table(t.Name())
+	relTableName := engine.Mapper.Obj2Table(relType.Name())
+	switch kind {
+	case belongsTo:
+		rel.fk = engine.Mapper.Obj2Table(field.Name) + "_id"
+	case hasOne, hasMany:
+		rel.fk = tableName + "_id"
+	case manyToMany:
+		rel.joinTable = tableName + "_" + relTableName
+		rel.fk = tableName + "_id"
+		rel.joinFk = relTableName + "_id"
+		if len(args) == 3 {
+			rel.joinTable, rel.fk, rel.joinFk = args[0], args[1], args[2]
+		}
+		return rel
+	}
+	if len(args) == 1 && args[0] != "" {
+		rel.fk = args[0]
+	}
+	return rel
+}
+
+// split the preload paths to the first level names and their sub paths,
+// "Orders.Items" means "Orders" and then "Items" of the orders
+func splitPreloads(paths []string) ([]string, map[string][]string) {
+	names := make([]string, 0)
+	subPaths := make(map[string][]string)
+	for _, path := range paths {
+		parts := strings.SplitN(path, ".", 2)
+		if _, ok := subPaths[parts[0]]; !ok {
+			names = append(names, parts[0])
+			subPaths[parts[0]] = make([]string, 0)
+		}
+		if len(parts) == 2 {
+			subPaths[parts[0]] = append(subPaths[parts[0]], parts[1])
+		}
+	}
+	return names, subPaths
+}
+
+// the key to match the foreign keys and primary keys, the types of them may
+// be different, for example int and int64
+func relKey(v interface{}) string {
+	if bs, ok := v.([]byte); ok {
+		return string(bs)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// a new session which uses the same context and transaction as session, and
+// reads from the master if session does
+func (session *Session) relSession() *Session {
+	rel := session.Engine.NewSession()
+	rel.ctx = session.ctx
+	rel.forceMaster = session.forceMaster
+	if !session.IsAutoCommit {
+		rel.Tx = session.Tx
+		rel.IsAutoCommit = false
+	}
+	return rel
+}
+
+// load the relations of the beans, beans are addressable structs of the same
+// type. Every relation of a level is loaded by IN queries, one query for
+// every chunk of the keys.
+func (session *Session) preload(beans []reflect.Value, paths []string) error {
+	if len(beans) == 0 || len(paths) == 0 {
+		return nil
+	}
+
+	table := session.Engine.autoMapType(beans[0].Type())
+	names, subPaths := splitPreloads(paths)
+	for _, name := range names {
+		rel, ok := table.relations[name]
+		if !ok {
+			return errors.New(fmt.Sprintf("%v has no relation %v", table.Name, name))
+		}
+		related, err := session.loadRelation(table, rel, beans)
+		if err != nil {
+			return err
+		}
+		err = session.preload(related, subPaths[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the key of the column's value of every bean, the zero values are skipped
+func colKeys(col *Column, beans []reflect.Value) ([]string, []interface{}) {
+	keys := make([]string, len(beans))
+	args := make([]interface{}, 0, len(beans))
+	exists := make(map[string]bool)
+	for i, bean := range beans {
+		v := col.ValueOf(bean.Addr().Interface())
+		if !v.IsValid() || isZero(v) {
+			continue
+		}
+		key := relKey(v.Interface())
+		keys[i] = key
+		if !exists[key] {
+			exists[key] = true
+			args = append(args, v.Interface())
+		}
+	}
+	return keys, args
+}
+
+func singlePK(table *Table) (*Column, error) {
+	if len(table.PrimaryKeys) != 1 {
+		return nil, errors.New(fmt.Sprintf("relation of %v needs one primary key", table.Name))
+	}
+	return table.PKColumns()[0], nil
+}
+
+// split the args of IN queries to chunks, the number of the arguments of a
+// statement is limited by the database
+func (session *Session) inChunks(args []interface{}) [][]interface{} {
+	chunk := len(args)
+	if maxArgs := session.Engine.dialect.MaxInsertArgs(); maxArgs > 0 && maxArgs < chunk {
+		chunk = maxArgs
+	}
+	chunks := make([][]interface{}, 0)
+	for start := 0; start < len(args); start += chunk {
+		end := start + chunk
+		if end > len(args) {
+			end = len(args)
+		}
+		chunks = append(chunks, args[start:end])
+	}
+	return chunks
+}
+
+// find the related structs whose column is in args, the structs are returned
+// as pointers
+func (session *Session) findRelated(t reflect.Type, col string, args []interface{}) ([]reflect.Value, error) {
+	related := make([]reflect.Value, 0)
+	for _, chunk := range session.inChunks(args) {
+		slice := reflect.New(reflect.SliceOf(reflect.PtrTo(t)))
+		err := session.In(session.Engine.Quote(col), chunk...).Find(slice.Interface())
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < slice.Elem().Len(); i++ {
+			related = append(related, slice.Elem().Index(i))
+		}
+	}
+	return related, nil
+}
+
+// group the related structs by the key of their column
+func groupRelated(col *Column, related []reflect.Value) map[string][]reflect.Value {
+	groups := make(map[string][]reflect.Value)
+	for _, r := range related {
+		key := relKey(col.ValueOf(r.Interface()).Interface())
+		groups[key] = append(groups[key], r)
+	}
+	return groups
+}
+
+// load one relation of the beans and return the loaded structs
+func (session *Session) loadRelation(table *Table, rel *relation, beans []reflect.Value) ([]reflect.Value, error) {
+	relTable := session.Engine.autoMapType(rel.relType)
+	var keys []string
+	var groups map[string][]reflect.Value
+
+	switch rel.kind {
+	case belongsTo:
+		fkCol, ok := table.Columns[rel.fk]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("%v has no column %v", table.Name, rel.fk))
+		}
+		pkCol, err := singlePK(relTable)
+		if err != nil {
+			return nil, err
+		}
+		var args []interface{}
+		keys, args = colKeys(fkCol, beans)
+		related, err := session.findRelated(rel.relType, pkCol.Name, args)
+		if err != nil {
+			return nil, err
+		}
+		groups = groupRelated(pkCol, related)
+	case hasOne, hasMany:
+		fkCol, ok := relTable.Columns[rel.fk]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("%v has no column %v", relTable.Name, rel.fk))
+		}
+		pkCol, err := singlePK(table)
+		if err != nil {
+			return nil, err
+		}
+		var args []interface{}
+		keys, args = colKeys(pkCol, beans)
+		related, err := session.findRelated(rel.relType, fkCol.Name, args)
+		if err != nil {
+			return nil, err
+		}
+		groups = groupRelated(fkCol, related)
+	case manyToMany:
+		pkCol, err := singlePK(table)
+		if err != nil {
+			return nil, err
+		}
+		relPkCol, err := singlePK(relTable)
+		if err != nil {
+			return nil, err
+		}
+		var args []interface{}
+		keys, args = colKeys(pkCol, beans)
+		if len(args) == 0 {
+			return nil, nil
+		}
+
+		quote := session.Engine.Quote
+		pairs := make([]map[string][]byte, 0)
+		for _, chunk := range session.inChunks(args) {
+			sql := fmt.Sprintf("SELECT %v, %v FROM %v WHERE %v IN (%v)", quote(rel.fk), quote(rel.joinFk),
+				quote(rel.joinTable), quote(rel.fk), strings.Join(makeArray("?", len(chunk)), ","))
+			chunkPairs, err := session.Query(sql, chunk...)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, chunkPairs...)
+		}
+		relArgs := make([]interface{}, 0, len(pairs))
+		exists := make(map[string]bool)
+		for _, pair := range pairs {
+			key := string(pair[rel.joinFk])
+			if !exists[key] {
+				exists[key] = true
+				relArgs = append(relArgs, key)
+			}
+		}
+		related, err := session.findRelated(rel.relType, relPkCol.Name, relArgs)
+		if err != nil {
+			return nil, err
+		}
+		relByPk := groupRelated(relPkCol, related)
+		groups = make(map[string][]reflect.Value)
+		for _, pair := range pairs {
+			key := string(pair[rel.fk])
+			groups[key] = append(groups[key], relByPk[string(pair[rel.joinFk])]...)
+		}
+	}
+
+	loaded := make([]reflect.Value, 0)
+	for i, bean := range beans {
+		if keys[i] == "" {
+			continue
+		}
+		if related, ok := groups[keys[i]]; ok {
+			loaded = append(loaded, setRelated(bean.FieldByName(rel.fieldName), related)...)
+		}
+	}
+	return loaded, nil
+}
+
+// set the related structs, which are pointers, to the field which could be T,
+// *T, []T or []*T, and return the addressable structs in the field
+func setRelated(field reflect.Value, related []reflect.Value) []reflect.Value {
+	loaded := make([]reflect.Value, 0, len(related))
+	switch field.Kind() {
+	case reflect.Slice:
+		slice := reflect.MakeSlice(field.Type(), 0, len(related))
+		for _, r := range related {
+			if field.Type().Elem().Kind() == reflect.Ptr {
+				slice = reflect.Append(slice, r)
+			} else {
+				slice = reflect.Append(slice, r.Elem())
+			}
+		}
+		field.Set(slice)
+		for i := 0; i < field.Len(); i++ {
+			loaded = append(loaded, reflect.Indirect(field.Index(i)))
+		}
+	case reflect.Ptr:
+		field.Set(related[0])
+		loaded = append(loaded, related[0].Elem())
+	default:
+		field.Set(related[0].Elem())
+		loaded = append(loaded, field)
+	}
+	return loaded
+}
@@ -0,0 +1,330 @@
+package xorm
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a scan plan maps the columns of the results to the fields of a table's
+// struct, it's resolved once for every column list of the table
+type scanPlan struct {
+	cols    []*Column
+	indexes [][]int
+	// the field is a Scanner which the column may be scanned into directly
+	direct []bool
+	// all the primary key columns are in the results
+	hasPks bool
+}
+
+var (
+	scannerType    = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	conversionType = reflect.TypeOf((*Conversion)(nil)).Elem()
+)
+
+// the scan plan of the result columns
+func (table *Table) scanPlan(fields []string) *scanPlan {
+	key := strings.Join(fields, ",")
+	table.scanMutex.Lock()
+	defer table.scanMutex.Unlock()
+	if plan, ok := table.scanPlans[key]; ok {
+		return plan
+	}
+
+	plan := &scanPlan{
+		cols:    make([]*Column, len(fields)),
+		indexes: make([][]int, len(fields)),
+		direct:  make([]bool, len(fields)),
+	}
+	pks := 0
+	for i, field := range fields {
+		col, ok := table.Columns[field]
+		if !ok {
+			col, ok = table.extendsColumn(field)
+		}
+		if !ok {
+			continue
+		}
+		index, ok := fieldIndex(table.Type, col.FieldName)
+		if !ok {
+			continue
+		}
+		plan.cols[i] = col
+		plan.indexes[i] = index
+		ptrType := reflect.PtrTo(table.Type.FieldByIndex(index).Type)
+		plan.direct[i] = ptrType.Implements(scannerType) && !ptrType.Implements(conversionType)
+		if col.IsPrimaryKey {
+			pks++
+		}
+	}
+	plan.hasPks = pks == len(table.PrimaryKeys)
+
+	if table.scanPlans == nil {
+		table.scanPlans = make(map[string]*scanPlan)
+	}
+	table.scanPlans[key] = plan
+	return plan
+}
+
+// the index of a field, the field of an extends struct is named as
+// "Struct.Field"
+func fieldIndex(t reflect.Type, fieldName string) ([]int, bool) {
+	var index []int
+	for _, name := range strings.Split(fieldName, ".") {
+		field, ok := t.FieldByName(name)
+		if !ok {
+			return nil, false
+		}
+		index = append(index, field.Index...)
+		t = field.Type
+	}
+	return index, true
+}
+
+// a row of the results, which is scanned by the scan plan, the scan
+// destinations are reused by the rows
+type resultRow struct {
+	session *Session
+	rows    *sql.Rows
+	plan    *scanPlan
+	direct  []bool
+	dests   []interface{}
+	values  []interface{}
+}
+
+func (row *resultRow) scan(bean reflect.Value) error {
+	if row.dests == nil {
+		row.dests = make([]interface{}, len(row.plan.cols))
+		row.values = make([]interface{}, len(row.plan.cols))
+		row.direct = row.session.directScans(row.plan, bean.Elem().Type())
+	}
+	return row.session.scanRow(row.rows, row.plan, bean, row.direct, row.dests, row.values)
+}
+
+// the columns which are scanned into the fields directly, the converters
+// may be registered after the plan is resolved, so they're checked for
+// every query
+func (session *Session) directScans(plan *scanPlan, t reflect.Type) []bool {
+	direct := make([]bool, len(plan.direct))
+	for i := range plan.direct {
+		if plan.direct[i] {
+			_, converted := session.Engine.converterOf(t.FieldByIndex(plan.indexes[i]).Type)
+			direct[i] = !converted
+		}
+	}
+	return direct
+}
+
+// query the sql and call fun for every row of the results, the rows are
+// scanned by the table's scan plan
+func (session *Session) scanRows(table *Table, sql string, args []interface{}, fun func(row *resultRow) error) error {
+	for _, filter := range session.Engine.Filters {
+		sql = filter.Do(sql, session)
+	}
+
+	session.Engine.LogSQL(sql)
+	session.Engine.LogSQL(args)
+
+	ctx, cancel := session.opContext()
+	defer cancel()
+
+	s, release, err := session.prepare(ctx, session.preparer(), sql)
+	if err != nil {
+		return err
+	}
+	defer release()
+	rows, err := s.QueryContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	fields, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	row := &resultRow{session: session, rows: rows, plan: table.scanPlan(fields)}
+	for rows.Next() {
+		err = fun(row)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// scan the current row into the struct which bean points to, a column which
+// is not the struct's is discarded, and a null column only sets a pointer or
+// a Scanner
+func (session *Session) scanRow(rows *sql.Rows, plan *scanPlan, bean reflect.Value, direct []bool,
+	dests, values []interface{}) error {
+	dataStruct := bean.Elem()
+	for i := range plan.cols {
+		if direct[i] {
+			dests[i] = dataStruct.FieldByIndex(plan.indexes[i]).Addr().Interface()
+		} else {
+			dests[i] = &values[i]
+		}
+	}
+	err := rows.Scan(dests...)
+	if err != nil {
+		return err
+	}
+
+	for i, col := range plan.cols {
+		if col == nil || direct[i] {
+			continue
+		}
+		err = session.setScanned(col, dataStruct.FieldByIndex(plan.indexes[i]), values[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set a scanned value to the field, the values which can't be set directly
+// are converted from bytes by bytes2Value
+func (session *Session) setScanned(col *Column, fieldValue reflect.Value, src interface{}) error {
+	if src == nil {
+		if fieldValue.Kind() == reflect.Ptr {
+			fieldValue.Set(reflect.Zero(fieldValue.Type()))
+		}
+		return nil
+	}
+	if _, ok := session.Engine.converterOf(fieldValue.Type()); ok {
+		data, err := scannedBytes(src)
+		if err != nil {
+			return err
+		}
+		return session.bytes2Value(col, &fieldValue, data)
+	}
+	if fieldValue.Kind() == reflect.Ptr {
+		x := reflect.New(fieldValue.Type().Elem())
+		_, converted := session.Engine.converterOf(x.Type().Elem())
+		var err error
+		if scanner, ok := x.Interface().(sql.Scanner); ok && !converted {
+			err = scanner.Scan(src)
+		} else {
+			err = session.setScanned(col, x.Elem(), src)
+		}
+		if err != nil {
+			return err
+		}
+		fieldValue.Set(x)
+		return nil
+	}
+
+	if t, ok := src.(time.Time); ok && fieldValue.Type() == timeType {
+		fieldValue.Set(reflect.ValueOf(session.Engine.dbTime(col, t)))
+		return nil
+	}
+	if _, ok := fieldValue.Addr().Interface().(Conversion); !ok && setPrimitive(col, fieldValue, src) {
+		return nil
+	}
+	data, err := scannedBytes(src)
+	if err != nil {
+		return err
+	}
+	return session.bytes2Value(col, &fieldValue, data)
+}
+
+// set the scanned value of a basic type to a field of the same kind, false
+// is returned if it should be converted by bytes2Value
+func setPrimitive(col *Column, v reflect.Value, src interface{}) bool {
+	switch v.Kind() {
+	case reflect.String:
+		switch s := src.(type) {
+		case []byte:
+			v.SetString(string(s))
+			return true
+		case string:
+			v.SetString(s)
+			return true
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// mysql's bit is returned as bytes
+		if col.SQLType.Name == Bit {
+			return false
+		}
+		switch s := src.(type) {
+		case int64:
+			v.SetInt(s)
+			return true
+		case []byte:
+			x, err := strconv.ParseInt(string(s), 10, 64)
+			if err == nil {
+				v.SetInt(x)
+				return true
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch s := src.(type) {
+		case int64:
+			v.SetUint(uint64(s))
+			return true
+		case []byte:
+			x, err := strconv.ParseUint(string(s), 10, 64)
+			if err == nil {
+				v.SetUint(x)
+				return true
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		switch s := src.(type) {
+		case float64:
+			v.SetFloat(s)
+			return true
+		case int64:
+			v.SetFloat(float64(s))
+			return true
+		}
+	case reflect.Bool:
+		switch s := src.(type) {
+		case bool:
+			v.SetBool(s)
+			return true
+		case int64:
+			v.SetBool(s != 0)
+			return true
+		}
+	}
+	return false
+}
+
+// convert a scanned value to bytes as a record of a query's results
+func scannedBytes(src interface{}) ([]byte, error) {
+	vv := reflect.ValueOf(src)
+	switch vv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return []byte(strconv.FormatInt(vv.Int(), 10)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return []byte(strconv.FormatUint(vv.Uint(), 10)), nil
+	case reflect.Float32, reflect.Float64:
+		return []byte(strconv.FormatFloat(vv.Float(), 'f', -1, 64)), nil
+	case reflect.String:
+		return []byte(vv.String()), nil
+	case reflect.Array, reflect.Slice:
+		if vv.Type().Elem().Kind() == reflect.Uint8 {
+			return vv.Bytes(), nil
+		}
+	case reflect.Struct:
+		if t, ok := src.(time.Time); ok {
+			// the time without a zone is kept as a time in the database's
+			// time zone
+			if zoneless(t) {
+				return []byte(t.Format("2006-01-02 15:04:05.999999999")), nil
+			}
+			return []byte(t.Format(time.RFC3339Nano)), nil
+		}
+	case reflect.Bool:
+		return []byte(strconv.FormatBool(vv.Bool())), nil
+	case reflect.Complex128, reflect.Complex64:
+		return []byte(fmt.Sprintf("%v", vv.Complex())), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unsupported struct type %v", vv.Type().Name()))
+}
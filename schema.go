@@ -0,0 +1,327 @@
+package xorm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the sql type of a column in database, SqlType may change the column
+// so a copy is used
+func colSqlType(d dialect, col *Column) string {
+	c := *col
+	return strings.ToUpper(d.SqlType(&c))
+}
+
+// postgres returns default values like 'abc'::character varying
+func normalizeDefault(s string) string {
+	s = strings.TrimSpace(s)
+	if idx := strings.Index(s, "::"); idx > 0 {
+		s = s[:idx]
+	}
+	return s
+}
+
+// compare a mapped column with the column retrieved from database, return the
+// differences of type, nullable and default. Primary keys and autoincrement
+// columns are not compared.
+func diffColumn(d dialect, expected, actual *Column) []string {
+	diffs := make([]string, 0)
+	if expected.IsPrimaryKey || expected.IsAutoIncrement {
+		return diffs
+	}
+
+	expectedType, actualType := colSqlType(d, expected), colSqlType(d, actual)
+	typeChanged := strings.Split(expectedType, "(")[0] != strings.Split(actualType, "(")[0]
+	// some databases don't return the length of all types, so only compare
+	// lengths when both of them have
+	if !typeChanged && expected.Length > 0 && actual.Length > 0 {
+		typeChanged = expected.Length != actual.Length || expected.Length2 != actual.Length2
+	}
+	if typeChanged {
+		diffs = append(diffs, fmt.Sprintf("type %v -> %v", actualType, expectedType))
+	}
+
+	if expected.Nullable != actual.Nullable {
+		diffs = append(diffs, fmt.Sprintf("nullable %v -> %v", actual.Nullable, expected.Nullable))
+	}
+
+	if normalizeDefault(expected.Default) != normalizeDefault(actual.Default) {
+		diffs = append(diffs, fmt.Sprintf("default %v -> %v", actual.Default, expected.Default))
+	}
+	return diffs
+}
+
+// find an index with the same type and columns
+func findIndex(indexes map[string]*Index, index *Index) *Index {
+	for _, idx := range indexes {
+		if idx.Type == index.Type && sliceEq(idx.Cols, index.Cols) {
+			return idx
+		}
+	}
+	return nil
+}
+
+func sortedIndexNames(indexes map[string]*Index) []string {
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// the name of an index in database which is created by xorm
+func xormIndexName(tableName string, index *Index) string {
+	if index.Type == UniqueType {
+		return uniqueName(tableName, index.Name)
+	}
+	return indexName(tableName, index.Name)
+}
+
+// ColumnDiff is a column whose type, nullable or default in database is
+// different from the mapped one
+type ColumnDiff struct {
+	Expected *Column
+	Actual   *Column
+	Diffs    []string
+}
+
+// TableDiff is the differences between a mapped struct and its table in
+// database. If the table is not exist, IsMissing is true and the others are
+// empty.
+type TableDiff struct {
+	Name              string
+	IsMissing         bool
+	MissingColumns    []*Column
+	ExtraColumns      []*Column
+	MismatchedColumns []*ColumnDiff
+	MissingIndexes    []*Index
+	ExtraIndexes      []*Index
+	MissingUniques    []*Index
+	ExtraUniques      []*Index
+}
+
+// check if the table in database is the same as the mapped
+func (diff *TableDiff) IsEmpty() bool {
+	return !diff.IsMissing && len(diff.MissingColumns) == 0 && len(diff.ExtraColumns) == 0 &&
+		len(diff.MismatchedColumns) == 0 && len(diff.MissingIndexes) == 0 &&
+		len(diff.ExtraIndexes) == 0 && len(diff.MissingUniques) == 0 && len(diff.ExtraUniques) == 0
+}
+
+// a readable report of the differences
+func (diff *TableDiff) String() string {
+	if diff.IsMissing {
+		return fmt.Sprintf("table %v is missing\n", diff.Name)
+	}
+	s := fmt.Sprintf("table %v:\n", diff.Name)
+	for _, col := range diff.MissingColumns {
+		s += fmt.Sprintf("\tmissing column %v\n", col.Name)
+	}
+	for _, col := range diff.ExtraColumns {
+		s += fmt.Sprintf("\textra column %v\n", col.Name)
+	}
+	for _, col := range diff.MismatchedColumns {
+		s += fmt.Sprintf("\tcolumn %v: %v\n", col.Expected.Name, strings.Join(col.Diffs, ", "))
+	}
+	for _, index := range diff.MissingIndexes {
+		s += fmt.Sprintf("\tmissing index %v(%v)\n", index.Name, strings.Join(index.Cols, ", "))
+	}
+	for _, index := range diff.ExtraIndexes {
+		s += fmt.Sprintf("\textra index %v(%v)\n", index.Name, strings.Join(index.Cols, ", "))
+	}
+	for _, index := range diff.MissingUniques {
+		s += fmt.Sprintf("\tmissing unique %v(%v)\n", index.Name, strings.Join(index.Cols, ", "))
+	}
+	for _, index := range diff.ExtraUniques {
+		s += fmt.Sprintf("\textra unique %v(%v)\n", index.Name, strings.Join(index.Cols, ", "))
+	}
+	return s
+}
+
+// compare a mapped table with the table retrieved from database, actual is
+// nil if the table is not exist
+func diffTable(d dialect, table, actual *Table) *TableDiff {
+	diff := &TableDiff{Name: table.Name}
+	if actual == nil {
+		diff.IsMissing = true
+		return diff
+	}
+
+	for _, name := range table.ColumnsSeq {
+		col := table.Columns[name]
+		actualCol, ok := actual.Columns[col.Name]
+		if !ok {
+			diff.MissingColumns = append(diff.MissingColumns, col)
+		} else if diffs := diffColumn(d, col, actualCol); len(diffs) > 0 {
+			diff.MismatchedColumns = append(diff.MismatchedColumns,
+				&ColumnDiff{Expected: col, Actual: actualCol, Diffs: diffs})
+		}
+	}
+	for _, name := range actual.ColumnsSeq {
+		if _, ok := table.Columns[name]; !ok {
+			diff.ExtraColumns = append(diff.ExtraColumns, actual.Columns[name])
+		}
+	}
+
+	// an index is the same if it has the same name or the same columns
+	for _, name := range sortedIndexNames(actual.Indexes) {
+		index := actual.Indexes[name]
+		if expected, ok := table.Indexes[name]; ok && expected.Type == index.Type &&
+			sliceEq(expected.Cols, index.Cols) {
+			continue
+		}
+		if _, ok := table.Indexes[name]; !ok && findIndex(table.Indexes, index) != nil {
+			continue
+		}
+		if index.Type == UniqueType {
+			diff.ExtraUniques = append(diff.ExtraUniques, index)
+		} else {
+			diff.ExtraIndexes = append(diff.ExtraIndexes, index)
+		}
+	}
+	for _, name := range sortedIndexNames(table.Indexes) {
+		index := table.Indexes[name]
+		if findIndex(actual.Indexes, index) != nil {
+			continue
+		}
+		if index.Type == UniqueType {
+			diff.MissingUniques = append(diff.MissingUniques, index)
+		} else {
+			diff.MissingIndexes = append(diff.MissingIndexes, index)
+		}
+	}
+	return diff
+}
+
+// compare a bean's mapped table with the table in database
+func (engine *Engine) diffBean(session *Session, bean interface{}) (*TableDiff, error) {
+	table := engine.autoMap(bean)
+	isExist, err := session.isTableExist(table.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !isExist {
+		return diffTable(engine.dialect, table, nil), nil
+	}
+
+	actual := &Table{Name: table.Name}
+	err = engine.loadTableMeta(actual)
+	if err != nil {
+		return nil, err
+	}
+	return diffTable(engine.dialect, table, actual), nil
+}
+
+// DiffSchema compares the beans' mapped tables with the tables in database,
+// and returns the differences of the tables which are not the same. Nothing
+// in database will be changed.
+func (engine *Engine) DiffSchema(beans ...interface{}) ([]*TableDiff, error) {
+	session := engine.NewSession()
+	defer session.Close()
+
+	diffs := make([]*TableDiff, 0)
+	for _, bean := range beans {
+		diff, err := engine.diffBean(session, bean)
+		if err != nil {
+			return nil, err
+		}
+		if !diff.IsEmpty() {
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs, nil
+}
+
+// generate the DDL to sync a table to its mapping
+func (engine *Engine) syncAlterSqls(bean interface{}) ([]string, error) {
+	session := engine.NewSession()
+	defer session.Close()
+	diff, err := engine.diffBean(session, bean)
+	if err != nil {
+		return nil, err
+	}
+
+	table := engine.autoMap(bean)
+	statement := &Statement{Engine: engine}
+	statement.Init()
+	statement.RefTable = table
+
+	sqls := make([]string, 0)
+	if diff.IsMissing {
+		sqls = append(sqls, statement.genCreateSQL())
+		sqls = append(sqls, statement.genIndexSQL()...)
+		sqls = append(sqls, statement.genUniqueSQL()...)
+		return sqls, nil
+	}
+
+	for _, col := range diff.MissingColumns {
+		sql, _ := statement.genAddColumnStr(col)
+		sqls = append(sqls, sql)
+	}
+	for _, col := range diff.MismatchedColumns {
+		modifySqls, err := engine.dialect.ModifyColumnSql(table.Name, col.Expected)
+		if err != nil {
+			return nil, err
+		}
+		sqls = append(sqls, modifySqls...)
+	}
+
+	// drop the removed or changed indexes which are created by xorm, an
+	// index which is not created by xorm will not be dropped.
+	for _, index := range append(diff.ExtraIndexes, diff.ExtraUniques...) {
+		isXorm, err := session.isIndexExist(table.Name, index.Name, index.Type == UniqueType)
+		if err != nil {
+			return nil, err
+		}
+		if isXorm {
+			sql, _ := statement.genDropIndexStr(xormIndexName(table.Name, index))
+			sqls = append(sqls, sql)
+		}
+	}
+
+	for _, index := range diff.MissingIndexes {
+		sql, _ := statement.genAddIndexStr(indexName(table.Name, index.Name), index.Cols)
+		sqls = append(sqls, sql)
+	}
+	for _, index := range diff.MissingUniques {
+		sql, _ := statement.genAddUniqueStr(uniqueName(table.Name, index.Name), index.Cols)
+		sqls = append(sqls, sql)
+	}
+	return sqls, nil
+}
+
+// SyncAlterDryRun returns the DDL which SyncAlter will execute, but does
+// not execute it.
+func (engine *Engine) SyncAlterDryRun(beans ...interface{}) ([]string, error) {
+	sqls := make([]string, 0)
+	for _, bean := range beans {
+		tableSqls, err := engine.syncAlterSqls(bean)
+		if err != nil {
+			return nil, err
+		}
+		sqls = append(sqls, tableSqls...)
+	}
+	return sqls, nil
+}
+
+// SyncAlter is like Sync, but it will also alter the changed columns' type,
+// nullable and default, and drop the removed or changed indexes which are
+// created by xorm. The executed DDL is returned.
+func (engine *Engine) SyncAlter(beans ...interface{}) ([]string, error) {
+	sqls := make([]string, 0)
+	for _, bean := range beans {
+		tableSqls, err := engine.syncAlterSqls(bean)
+		if err != nil {
+			return sqls, err
+		}
+		for _, sql := range tableSqls {
+			_, err = engine.Exec(sql)
+			if err != nil {
+				return sqls, err
+			}
+			sqls = append(sqls, sql)
+		}
+	}
+	return sqls, nil
+}
@@ -1,7 +1,9 @@
 package xorm
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,6 +24,16 @@ type Session struct {
 	IsCommitedOrRollbacked bool
 	TransType              string
 	IsAutoClose            bool
+	ctx                    context.Context
+	savepoints             int
+	conn                   *sql.Conn
+	resetSqls              []string
+	forceMaster            bool
+	slaveDb                *sql.DB
+	slave                  *Engine
+	txStmts                *stmtCache
+	beforeClosures         []func(interface{})
+	afterClosures          []func(interface{})
 }
 
 // Method Init reset the session as the init status.
@@ -31,11 +43,23 @@ func (session *Session) Init() {
 	session.IsAutoCommit = true
 	session.IsCommitedOrRollbacked = false
 	session.IsAutoClose = false
+	session.ctx = nil
+	session.savepoints = 0
+	session.forceMaster = false
+	session.beforeClosures = nil
+	session.afterClosures = nil
 }
 
 // Method Close release the connection from pool
 func (session *Session) Close() {
 	defer func() {
+		if session.conn != nil {
+			// the connection could be reset only after the transaction ends
+			if !session.IsAutoCommit && !session.IsCommitedOrRollbacked {
+				session.Rollback()
+			}
+			session.releaseConn()
+		}
 		if session.Db != nil {
 			session.Engine.Pool.ReleaseDB(session.Engine, session.Db)
 			session.Db = nil
@@ -52,33 +76,51 @@ func (session *Session) Sql(querystring string, args ...interface{}) *Session {
 	return session
 }
 
-// Method Where provides custom query condition.
-func (session *Session) Where(querystring string, args ...interface{}) *Session {
-	session.Statement.Where(querystring, args...)
+// Method Where provides custom query condition, query could be a string or a Cond.
+func (session *Session) Where(query interface{}, args ...interface{}) *Session {
+	session.Statement.Where(query, args...)
 	return session
 }
 
 // Method Where provides custom query condition.
-func (session *Session) And(querystring string, args ...interface{}) *Session {
-	session.Statement.And(querystring, args...)
+func (session *Session) And(query interface{}, args ...interface{}) *Session {
+	session.Statement.And(query, args...)
 	return session
 }
 
 // Method Where provides custom query condition.
-func (session *Session) Or(querystring string, args ...interface{}) *Session {
-	session.Statement.Or(querystring, args...)
+func (session *Session) Or(query interface{}, args ...interface{}) *Session {
+	session.Statement.Or(query, args...)
 	return session
 }
 
-// Method Id provides converting id as a query condition
-func (session *Session) Id(id int64) *Session {
+// Method Id provides converting id as a query condition, id could be a PK
+// for composite primary keys
+func (session *Session) Id(id interface{}) *Session {
 	session.Statement.Id(id)
 	return session
 }
 
+// ShardKey routes the operation to the shard table of the key when the
+// table is sharded and the key can't be found from the beans
+func (session *Session) ShardKey(key interface{}) *Session {
+	session.Statement.shardKey = key
+	return session
+}
+
 // Method Table can input a string or pointer to struct for special a table to operate.
-func (session *Session) Table(tableNameOrBean interface{}) *Session {
-	session.Statement.Table(tableNameOrBean)
+func (session *Session) Table(tableNameOrBean interface{}, alias ...string) *Session {
+	session.Statement.Table(tableNameOrBean, alias...)
+	return session
+}
+
+// Method Preload loads the relations after Find or Get, every level of the
+// relations is loaded by one IN query. A relation could be a field of a
+// loaded relation, for example:
+//
+//		engine.Preload("Orders", "Orders.Items").Find(&users)
+func (session *Session) Preload(relations ...string) *Session {
+	session.Statement.preloads = append(session.Statement.preloads, relations...)
 	return session
 }
 
@@ -118,6 +160,14 @@ func (session *Session) Omit(columns ...string) *Session {
 	return session
 }
 
+// ChunksInTx inserts all the chunks of a large slice in one transaction, the
+// chunks are inserted in a nested transaction if the session is in a
+// transaction
+func (session *Session) ChunksInTx() *Session {
+	session.Statement.chunksInTx = true
+	return session
+}
+
 // Method NoAutoTime means do not automatically give created field and updated field
 // the current time on the current session temporarily
 func (session *Session) NoAutoTime() *Session {
@@ -187,6 +237,20 @@ func (session *Session) NoCache() *Session {
 	return session
 }
 
+// Unscoped makes Get, Find, Count and Iterate include the soft deleted
+// records, and Delete delete the records from the table
+func (session *Session) Unscoped() *Session {
+	session.Statement.unscoped = true
+	return session
+}
+
+// ForceMaster makes the reads of this session executed by the master when the
+// engine is in an engine group
+func (session *Session) ForceMaster() *Session {
+	session.forceMaster = true
+	return session
+}
+
 //The join_operator should be one of INNER, LEFT OUTER, CROSS etc - this will be prepended to JOIN
 func (session *Session) Join(join_operator, tablename, condition string) *Session {
 	session.Statement.Join(join_operator, tablename, condition)
@@ -205,7 +269,30 @@ func (session *Session) Having(conditions string) *Session {
 	return session
 }
 
+// Method Context sets the context of the session, all the database operations
+// and the transaction of the session will be cancelled when the context is done.
+func (session *Session) Context(ctx context.Context) *Session {
+	session.ctx = ctx
+	return session
+}
+
+// return the context for one database operation. If no context is set and
+// engine's QueryTimeout is not zero, a context with the timeout is returned.
+func (session *Session) opContext() (context.Context, context.CancelFunc) {
+	if session.ctx != nil {
+		return session.ctx, func() {}
+	}
+	if session.Engine.QueryTimeout > 0 {
+		return context.WithTimeout(context.Background(), session.Engine.QueryTimeout)
+	}
+	return context.Background(), func() {}
+}
+
 func (session *Session) newDb() error {
+	// the results may come from the cache, so check the context at first
+	if session.ctx != nil && session.ctx.Err() != nil {
+		return session.ctx.Err()
+	}
 	if session.Db == nil {
 		db, err := session.Engine.Pool.RetrieveDB(session.Engine)
 		if err != nil {
@@ -216,98 +303,235 @@ func (session *Session) newDb() error {
 	return nil
 }
 
-// Begin a transaction
+// Begin a transaction, if the session is already in a transaction, a
+// savepoint is created as a nested transaction, and the nested Commit or
+// Rollback will release or rollback to the savepoint. Only the outermost
+// Commit will commit the transaction.
 func (session *Session) Begin() error {
+	return session.BeginWith(nil)
+}
+
+// BeginWith begins a transaction with the isolation level and read only mode
+// of opts, the statements like "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE"
+// are generated by the dialect. A nested transaction can't have options.
+func (session *Session) BeginWith(opts *sql.TxOptions) error {
 	err := session.newDb()
 	if err != nil {
 		return err
 	}
-	if session.IsAutoCommit {
-		tx, err := session.Db.Begin()
+	hasOpts := opts != nil && (opts.Isolation != sql.LevelDefault || opts.ReadOnly)
+	if session.IsAutoCommit || session.IsCommitedOrRollbacked {
+		// QueryTimeout is for one operation, so it's not applied to the transaction
+		ctx := session.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if hasOpts {
+			return session.beginWithOptions(ctx, opts)
+		}
+		tx, err := session.Db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
 		session.IsAutoCommit = false
 		session.IsCommitedOrRollbacked = false
+		session.savepoints = 0
 		session.Tx = tx
 
 		session.Engine.LogSQL("BEGIN TRANSACTION")
+		return nil
 	}
-	return nil
+
+	if hasOpts {
+		return errors.New("a nested transaction can't set isolation level or read only")
+	}
+	session.savepoints++
+	savepoint, _, _ := session.Engine.dialect.SavepointSql(session.savepointName())
+	_, err = session.exec(savepoint)
+	if err != nil {
+		session.savepoints--
+	}
+	return err
 }
 
-// When using transaction, you can rollback if any error
-func (session *Session) Rollback() error {
-	if !session.IsAutoCommit && !session.IsCommitedOrRollbacked {
-		session.Engine.LogSQL("ROLL BACK")
-		session.IsCommitedOrRollbacked = true
-		return session.Tx.Rollback()
+// begin a transaction on a dedicated connection, so the statements before
+// BEGIN are executed on the same connection as the transaction
+func (session *Session) beginWithOptions(ctx context.Context, opts *sql.TxOptions) error {
+	beforeSqls, afterSqls, resetSqls, err := session.Engine.dialect.SetTransactionSql(opts.Isolation, opts.ReadOnly)
+	if err != nil {
+		return err
+	}
+	conn, err := session.Db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	session.conn = conn
+	session.resetSqls = resetSqls
+
+	for _, sql := range beforeSqls {
+		session.Engine.LogSQL(sql)
+		_, err = conn.ExecContext(ctx, sql)
+		if err != nil {
+			session.releaseConn()
+			return err
+		}
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		session.releaseConn()
+		return err
+	}
+	session.IsAutoCommit = false
+	session.IsCommitedOrRollbacked = false
+	session.savepoints = 0
+	session.Tx = tx
+	session.Engine.LogSQL("BEGIN TRANSACTION")
+
+	for _, sql := range afterSqls {
+		_, err = session.exec(sql)
+		if err != nil {
+			session.Rollback()
+			return err
+		}
 	}
 	return nil
 }
 
-// When using transaction, Commit will commit all operations.
-func (session *Session) Commit() error {
-	if !session.IsAutoCommit && !session.IsCommitedOrRollbacked {
-		session.Engine.LogSQL("COMMIT")
-		session.IsCommitedOrRollbacked = true
-		return session.Tx.Commit()
+// reset the connection of the transaction with options and return it to the
+// pool, it should be called after the transaction ends
+func (session *Session) releaseConn() {
+	if session.conn == nil {
+		return
 	}
-	return nil
+	for _, sql := range session.resetSqls {
+		session.Engine.LogSQL(sql)
+		_, err := session.conn.ExecContext(context.Background(), sql)
+		if err != nil {
+			session.Engine.LogError(err)
+		}
+	}
+	session.conn.Close()
+	session.conn = nil
+	session.resetSqls = nil
 }
 
-func (session *Session) scanMapIntoStruct(obj interface{}, objMap map[string][]byte) error {
-	dataStruct := reflect.Indirect(reflect.ValueOf(obj))
-	if dataStruct.Kind() != reflect.Struct {
-		return errors.New("Expected a pointer to a struct")
+// the savepoint name of the current nested transaction
+func (session *Session) savepointName() string {
+	return fmt.Sprintf("xorm_savepoint_%v", session.savepoints)
+}
+
+// Transaction runs fun in a transaction, the transaction is committed if fun
+// returns nil and rollbacked if fun returns an error or panics, the panic is
+// re-panicked after rollback. If the session is already in a transaction, a
+// nested transaction is used. Otherwise when the database reports a deadlock
+// or a serialization failure, fun is retried at most Engine.TxRetries times.
+func (session *Session) Transaction(fun func(*Session) error) error {
+	return session.TransactionWith(nil, fun)
+}
+
+// TransactionWith is like Transaction, but the transaction has the isolation
+// level and read only mode of opts
+func (session *Session) TransactionWith(opts *sql.TxOptions, fun func(*Session) error) error {
+	isNested := !session.IsAutoCommit && !session.IsCommitedOrRollbacked
+	for i := 0; ; i++ {
+		err := session.transaction(opts, fun)
+		if err == nil || isNested || i >= session.Engine.TxRetries ||
+			!session.Engine.dialect.IsRetryableError(err) {
+			return err
+		}
+		session.Engine.LogWarn("[transaction] retry", i+1, err)
 	}
+}
 
-	table := session.Engine.autoMapType(rType(obj))
+func (session *Session) transaction(opts *sql.TxOptions, fun func(*Session) error) error {
+	// the session returns to the state before the transaction when it's not
+	// nested, so it could be used without a transaction as before
+	autoCommit, done, tx := session.IsAutoCommit, session.IsCommitedOrRollbacked, session.Tx
+	if autoCommit || done {
+		defer func() {
+			session.IsAutoCommit, session.IsCommitedOrRollbacked, session.Tx = autoCommit, done, tx
+		}()
+	}
 
-	for key, data := range objMap {
-		if _, ok := table.Columns[key]; !ok {
-			session.Engine.LogWarn("table %v's has not column %v.", table.Name, key)
-			continue
+	err := session.BeginWith(opts)
+	if err != nil {
+		return err
+	}
+	// the nested transactions which fun doesn't finish are finished together
+	savepoints := session.savepoints
+	defer func() {
+		if p := recover(); p != nil {
+			session.savepoints = savepoints
+			session.Rollback()
+			panic(p)
 		}
-		col := table.Columns[key]
-		fieldName := col.FieldName
-		fieldPath := strings.Split(fieldName, ".")
-		var fieldValue reflect.Value
-		if len(fieldPath) > 2 {
-			session.Engine.LogError("Unsupported mutliderive", fieldName)
-			continue
-		} else if len(fieldPath) == 2 {
-			parentField := dataStruct.FieldByName(fieldPath[0])
-			if parentField.IsValid() {
-				fieldValue = parentField.FieldByName(fieldPath[1])
+	}()
+
+	err = fun(session)
+	session.savepoints = savepoints
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+	return session.Commit()
+}
+
+// When using transaction, you can rollback if any error. In a nested
+// transaction, only the operations after its Begin are rollbacked.
+func (session *Session) Rollback() error {
+	if !session.IsAutoCommit && !session.IsCommitedOrRollbacked {
+		if session.savepoints > 0 {
+			_, release, rollbackTo := session.Engine.dialect.SavepointSql(session.savepointName())
+			session.savepoints--
+			_, err := session.exec(rollbackTo)
+			if err != nil {
+				return err
 			}
-		} else {
-			fieldValue = dataStruct.FieldByName(fieldName)
-		}
-		if !fieldValue.IsValid() || !fieldValue.CanSet() {
-			session.Engine.LogWarn("table %v's column %v is not valid or cannot set",
-				table.Name, key)
-			continue
+			_, err = session.exec(release)
+			return err
 		}
+		session.Engine.LogSQL("ROLL BACK")
+		session.IsCommitedOrRollbacked = true
+		session.closeTxStmts()
+		err := session.Tx.Rollback()
+		session.releaseConn()
+		return err
+	}
+	return nil
+}
 
-		err := session.bytes2Value(col, &fieldValue, data)
-		if err != nil {
+// When using transaction, Commit will commit all operations. In a nested
+// transaction, Commit only releases its savepoint.
+func (session *Session) Commit() error {
+	if !session.IsAutoCommit && !session.IsCommitedOrRollbacked {
+		if session.savepoints > 0 {
+			_, release, _ := session.Engine.dialect.SavepointSql(session.savepointName())
+			session.savepoints--
+			_, err := session.exec(release)
 			return err
 		}
+		session.Engine.LogSQL("COMMIT")
+		session.IsCommitedOrRollbacked = true
+		session.closeTxStmts()
+		err := session.Tx.Commit()
+		session.releaseConn()
+		return err
 	}
-
 	return nil
 }
 
 //Execute sql
 func (session *Session) innerExec(sql string, args ...interface{}) (sql.Result, error) {
-	rs, err := session.Db.Prepare(sql)
+	ctx, cancel := session.opContext()
+	defer cancel()
+
+	rs, release, err := session.prepare(ctx, session.Db, sql)
 	if err != nil {
 		return nil, err
 	}
-	defer rs.Close()
+	defer release()
 
-	res, err := rs.Exec(args...)
+	res, err := rs.ExecContext(ctx, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -325,7 +549,17 @@ func (session *Session) exec(sql string, args ...interface{}) (sql.Result, error
 	if session.IsAutoCommit {
 		return session.innerExec(sql, args...)
 	}
-	return session.Tx.Exec(sql, args...)
+	ctx, cancel := session.opContext()
+	defer cancel()
+	if session.stmtCache(session.Tx) == nil {
+		return session.Tx.ExecContext(ctx, sql, args...)
+	}
+	s, release, err := session.prepare(ctx, session.Tx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return s.ExecContext(ctx, args...)
 }
 
 // Exec raw sql
@@ -481,22 +715,58 @@ func (session *Session) DropTable(bean interface{}) error {
 
 func (statement *Statement) convertIdSql(sql string) string {
 	if statement.RefTable != nil {
-		col := statement.RefTable.PKColumn()
-		if col != nil {
+		cols := statement.RefTable.PKColumns()
+		if len(cols) > 0 {
 			sqls := splitNNoCase(sql, "from", 2)
 			if len(sqls) != 2 {
 				return ""
 			}
-			newsql := fmt.Sprintf("SELECT %v.%v FROM %v", statement.Engine.Quote(statement.TableName()),
-				statement.Engine.Quote(col.Name), sqls[1])
+			colStrs := make([]string, 0, len(cols))
+			for _, col := range cols {
+				colStrs = append(colStrs, statement.Engine.Quote(statement.TableName())+"."+
+					statement.Engine.Quote(col.Name))
+			}
+			newsql := fmt.Sprintf("SELECT %v FROM %v", strings.Join(colStrs, ", "), sqls[1])
 			return newsql
 		}
 	}
 	return ""
 }
 
+// convert a record's primary key columns to a PK
+func (session *Session) record2PK(table *Table, record map[string][]byte) (PK, error) {
+	pk := make(PK, 0, len(table.PrimaryKeys))
+	bean := reflect.New(table.Type).Interface()
+	for _, col := range table.PKColumns() {
+		data, ok := record[col.Name]
+		if !ok {
+			return nil, errors.New("no id")
+		}
+		fieldValue := col.ValueOf(bean)
+		err := session.bytes2Value(col, &fieldValue, data)
+		if err != nil {
+			return nil, err
+		}
+		pk = append(pk, fieldValue.Interface())
+	}
+	return pk, nil
+}
+
+// convert records' primary key columns to PKs
+func (session *Session) records2PKs(table *Table, records []map[string][]byte) ([]PK, error) {
+	pks := make([]PK, 0, len(records))
+	for _, record := range records {
+		pk, err := session.record2PK(table, record)
+		if err != nil {
+			return nil, err
+		}
+		pks = append(pks, pk)
+	}
+	return pks, nil
+}
+
 func (session *Session) cacheGet(bean interface{}, sql string, args ...interface{}) (has bool, err error) {
-	if session.Statement.RefTable == nil || session.Statement.RefTable.PrimaryKey == "" {
+	if session.Statement.RefTable == nil || len(session.Statement.RefTable.PrimaryKeys) == 0 {
 		return false, ErrCacheFailed
 	}
 	for _, filter := range session.Engine.Filters {
@@ -517,17 +787,11 @@ func (session *Session) cacheGet(bean interface{}, sql string, args ...interface
 			return false, err
 		}
 		session.Engine.LogDebug("[xorm:cacheGet] query ids:", resultsSlice)
-		ids = make([]int64, 0)
+		ids = make([]PK, 0)
 		if len(resultsSlice) > 0 {
-			data := resultsSlice[0]
-			var id int64
-			if v, ok := data[session.Statement.RefTable.PrimaryKey]; !ok {
+			id, err := session.record2PK(session.Statement.RefTable, resultsSlice[0])
+			if err != nil {
 				return false, ErrCacheFailed
-			} else {
-				id, err = strconv.ParseInt(string(v), 10, 64)
-				if err != nil {
-					return false, err
-				}
 			}
 			ids = append(ids, id)
 		}
@@ -543,25 +807,32 @@ func (session *Session) cacheGet(bean interface{}, sql string, args ...interface
 	if len(ids) > 0 {
 		structValue := reflect.Indirect(reflect.ValueOf(bean))
 		id := ids[0]
-		session.Engine.LogDebug("[xorm:cacheGet] get bean:", tableName, id)
-		cacheBean := cacher.GetBean(tableName, id)
+		sid, err := id.ToString()
+		if err != nil {
+			return false, err
+		}
+		session.Engine.LogDebug("[xorm:cacheGet] get bean:", tableName, sid)
+		cacheBean := cacher.GetBean(tableName, sid)
 		if cacheBean == nil {
 			newSession := session.Engine.NewSession()
 			defer newSession.Close()
 			cacheBean = reflect.New(structValue.Type()).Interface()
+			// the id is found by the statement, the soft deleted record
+			// should be loaded too
+			newSession.Unscoped()
 			if session.Statement.AltTableName != "" {
-				has, err = newSession.Id(id).NoCache().Table(session.Statement.AltTableName).Get(cacheBean)
+				has, err = newSession.Id(id).NoCache().Table(session.Statement.AltTableName).get(cacheBean)
 			} else {
-				has, err = newSession.Id(id).NoCache().Get(cacheBean)
+				has, err = newSession.Id(id).NoCache().get(cacheBean)
 			}
 			if err != nil || !has {
 				return has, err
 			}
 
-			session.Engine.LogDebug("[xorm:cacheGet] cache bean:", tableName, id, cacheBean)
-			cacher.PutBean(tableName, id, cacheBean)
+			session.Engine.LogDebug("[xorm:cacheGet] cache bean:", tableName, sid, cacheBean)
+			cacher.PutBean(tableName, sid, cacheBean)
 		} else {
-			session.Engine.LogDebug("[xorm:cacheGet] cached bean:", tableName, id, cacheBean)
+			session.Engine.LogDebug("[xorm:cacheGet] cached bean:", tableName, sid, cacheBean)
 			has = true
 		}
 		structValue.Set(reflect.Indirect(reflect.ValueOf(cacheBean)))
@@ -573,7 +844,7 @@ func (session *Session) cacheGet(bean interface{}, sql string, args ...interface
 
 func (session *Session) cacheFind(t reflect.Type, sql string, rowsSlicePtr interface{}, args ...interface{}) (err error) {
 	if session.Statement.RefTable == nil ||
-		session.Statement.RefTable.PrimaryKey == "" ||
+		len(session.Statement.RefTable.PrimaryKeys) == 0 ||
 		indexNoCase(sql, "having") != -1 ||
 		indexNoCase(sql, "group by") != -1 {
 		return ErrCacheFailed
@@ -604,21 +875,9 @@ func (session *Session) cacheFind(t reflect.Type, sql string, rowsSlicePtr inter
 		}
 
 		tableName := session.Statement.TableName()
-		ids = make([]int64, 0)
-		if len(resultsSlice) > 0 {
-			for _, data := range resultsSlice {
-				//fmt.Println(data)
-				var id int64
-				if v, ok := data[session.Statement.RefTable.PrimaryKey]; !ok {
-					return errors.New("no id")
-				} else {
-					id, err = strconv.ParseInt(string(v), 10, 64)
-					if err != nil {
-						return err
-					}
-				}
-				ids = append(ids, id)
-			}
+		ids, err = session.records2PKs(table, resultsSlice)
+		if err != nil {
+			return err
 		}
 		session.Engine.LogDebug("[xorm:cacheFind] cache ids:", ids, tableName, newsql, args)
 		err = putCacheSql(cacher, ids, tableName, newsql, args)
@@ -630,23 +889,30 @@ func (session *Session) cacheFind(t reflect.Type, sql string, rowsSlicePtr inter
 	}
 
 	sliceValue := reflect.Indirect(reflect.ValueOf(rowsSlicePtr))
-	pkFieldName := session.Statement.RefTable.PKColumn().FieldName
 
-	ididxes := make(map[int64]int)
-	var ides []interface{} = make([]interface{}, 0)
+	ididxes := make(map[string]int)
+	var ides []PK = make([]PK, 0)
 	var temps []interface{} = make([]interface{}, len(ids))
 	tableName := session.Statement.TableName()
 	for idx, id := range ids {
-		bean := cacher.GetBean(tableName, id)
+		sid, err := id.ToString()
+		if err != nil {
+			return err
+		}
+		bean := cacher.GetBean(tableName, sid)
 		if bean == nil {
 			ides = append(ides, id)
-			ididxes[id] = idx
+			ididxes[sid] = idx
 		} else {
-			session.Engine.LogDebug("[xorm:cacheFind] cached bean:", tableName, id, bean)
+			session.Engine.LogDebug("[xorm:cacheFind] cached bean:", tableName, sid, bean)
 
-			sid := reflect.Indirect(reflect.ValueOf(bean)).FieldByName(pkFieldName).Int()
-			if sid != id {
-				session.Engine.LogError("[xorm:cacheFind] error cache", id, sid, bean)
+			pk := table.pkValues(bean)
+			xid, err := pk.ToString()
+			if err != nil {
+				return err
+			}
+			if sid != xid {
+				session.Engine.LogError("[xorm:cacheFind] error cache", sid, xid, bean)
 				return ErrCacheFailed
 			}
 			temps[idx] = bean
@@ -656,12 +922,25 @@ func (session *Session) cacheFind(t reflect.Type, sql string, rowsSlicePtr inter
 	if len(ides) > 0 {
 		newSession := session.Engine.NewSession()
 		defer newSession.Close()
+		if session.Statement.AltTableName != "" {
+			newSession.Table(session.Statement.AltTableName)
+		}
+		newSession.Unscoped()
 
 		slices := reflect.New(reflect.SliceOf(t))
 		beans := slices.Interface()
-		//beans := reflect.New(sliceValue.Type()).Interface()
-		//err = newSession.In("(id)", ides...).OrderBy(session.Statement.OrderStr).NoCache().Find(beans)
-		err = newSession.In("(id)", ides...).NoCache().Find(beans)
+		if len(table.PrimaryKeys) == 1 {
+			ff := make([]interface{}, 0, len(ides))
+			for _, ie := range ides {
+				ff = append(ff, ie[0])
+			}
+			newSession.In("(id)", ff...)
+		} else {
+			newSession.Statement.RefTable = table
+			cond, args := newSession.Statement.pksCondition(ides)
+			newSession.Where(cond, args...)
+		}
+		err = newSession.NoCache().find(beans)
 		if err != nil {
 			return err
 		}
@@ -673,19 +952,21 @@ func (session *Session) cacheFind(t reflect.Type, sql string, rowsSlicePtr inter
 				rv = rv.Addr()
 			}
 			bean := rv.Interface()
-			id := reflect.Indirect(reflect.ValueOf(bean)).FieldByName(pkFieldName).Int()
-			//bean := vs.Index(i).Addr().Interface()
-			temps[ididxes[id]] = bean
-			//temps[idxes[i]] = bean
-			session.Engine.LogDebug("[xorm:cacheFind] cache bean:", tableName, id, bean)
-			cacher.PutBean(tableName, id, bean)
+			pk := table.pkValues(bean)
+			sid, err := pk.ToString()
+			if err != nil {
+				return err
+			}
+			temps[ididxes[sid]] = bean
+			session.Engine.LogDebug("[xorm:cacheFind] cache bean:", tableName, sid, bean)
+			cacher.PutBean(tableName, sid, bean)
 		}
 	}
 
 	for j := 0; j < len(temps); j++ {
 		bean := temps[j]
 		if bean == nil {
-			session.Engine.LogError("[xorm:cacheFind] cache error:", tableName, ides[j], bean)
+			session.Engine.LogError("[xorm:cacheFind] cache error:", tableName, ids[j], bean)
 			return errors.New("cache error")
 		}
 		if sliceValue.Kind() == reflect.Slice {
@@ -695,16 +976,14 @@ func (session *Session) cacheFind(t reflect.Type, sql string, rowsSlicePtr inter
 				sliceValue.Set(reflect.Append(sliceValue, reflect.Indirect(reflect.ValueOf(bean))))
 			}
 		} else if sliceValue.Kind() == reflect.Map {
-			var key int64
-			if table.PrimaryKey != "" {
-				key = ids[j]
-			} else {
-				key = int64(j)
+			key, err := pk2MapKey(ids[j], sliceValue.Type().Key())
+			if err != nil {
+				return err
 			}
 			if t.Kind() == reflect.Ptr {
-				sliceValue.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(bean))
+				sliceValue.SetMapIndex(key, reflect.ValueOf(bean))
 			} else {
-				sliceValue.SetMapIndex(reflect.ValueOf(key), reflect.Indirect(reflect.ValueOf(bean)))
+				sliceValue.SetMapIndex(key, reflect.Indirect(reflect.ValueOf(bean)))
 			}
 		}
 		/*} else {
@@ -726,7 +1005,18 @@ type IterFunc func(idx int, bean interface{}) error
 // are conditions. beans could be []Struct, []*Struct, map[int64]Struct
 // map[int64]*Struct
 func (session *Session) Iterate(bean interface{}, fun IterFunc) error {
-	err := session.newDb()
+	shards, err := session.routeShard(rType(bean), bean)
+	if err != nil {
+		session.resetOp()
+		return err
+	}
+	if shards != nil {
+		return session.eachShard(shards, func() error {
+			return session.Iterate(bean, fun)
+		})
+	}
+
+	err = session.newDb()
 	if err != nil {
 		return err
 	}
@@ -735,63 +1025,80 @@ func (session *Session) Iterate(bean interface{}, fun IterFunc) error {
 	if session.IsAutoClose {
 		defer session.Close()
 	}
+	defer session.useSlave()()
 
 	var sql string
 	var args []interface{}
 	session.Statement.RefTable = session.Engine.autoMap(bean)
 	if session.Statement.RawSQL == "" {
-		sql, args = session.Statement.genGetSql(bean)
+		sql, args, err = session.Statement.genGetSql(bean)
+		if err != nil {
+			return err
+		}
 	} else {
 		sql = session.Statement.RawSQL
 		args = session.Statement.RawParams
 	}
 
-	for _, filter := range session.Engine.Filters {
-		sql = filter.Do(sql, session)
-	}
-
-	session.Engine.LogSQL(sql)
-	session.Engine.LogSQL(args)
-
-	s, err := session.Db.Prepare(sql)
-	if err != nil {
-		return err
-	}
-	defer s.Close()
-	rows, err := s.Query(args...)
-	if err != nil {
-		return err
-	}
-	defer rows.Close()
-
-	fields, err := rows.Columns()
-	if err != nil {
-		return err
-	}
 	t := reflect.Indirect(reflect.ValueOf(bean)).Type()
-	b := reflect.New(t).Interface()
+	b := reflect.New(t)
+	loader, isLoader := b.Interface().(AfterLoadProcessor)
+	var rel *Session
+	if isLoader {
+		rel = session.relSession()
+		defer rel.Close()
+	}
 	i := 0
-	for rows.Next() {
-		result, err := row2map(rows, fields)
-		if err == nil {
-			err = session.scanMapIntoStruct(b, result)
-		}
-		if err == nil {
-			err = fun(i, b)
-			i = i + 1
-		}
+	return session.scanRows(session.Engine.autoMapType(t), sql, args, func(row *resultRow) error {
+		err := row.scan(b)
 		if err != nil {
 			return err
 		}
-	}
-
-	return nil
+		if isLoader {
+			loader.AfterLoad(rel)
+		}
+		err = fun(i, b.Interface())
+		i = i + 1
+		return err
+	})
 }
 
 // get retrieve one record from database, bean's non-empty fields
 // will be as conditions
 func (session *Session) Get(bean interface{}) (bool, error) {
-	err := session.newDb()
+	preloads := session.Statement.preloads
+	loader, isLoader := bean.(AfterLoadProcessor)
+	if len(preloads) == 0 && !isLoader {
+		return session.get(bean)
+	}
+	// the context and transaction should be kept before the session is reset
+	rel := session.relSession()
+	defer rel.Close()
+	has, err := session.get(bean)
+	if err != nil || !has {
+		return has, err
+	}
+	err = rel.preload([]reflect.Value{reflect.Indirect(reflect.ValueOf(bean))}, preloads)
+	if err != nil {
+		return true, err
+	}
+	if isLoader {
+		loader.AfterLoad(rel)
+	}
+	return true, nil
+}
+
+func (session *Session) get(bean interface{}) (bool, error) {
+	shards, err := session.routeShard(rType(bean), bean)
+	if err != nil {
+		session.resetOp()
+		return false, err
+	}
+	if shards != nil {
+		return session.getShards(shards, bean)
+	}
+
+	err = session.newDb()
 	if err != nil {
 		return false, err
 	}
@@ -800,48 +1107,64 @@ func (session *Session) Get(bean interface{}) (bool, error) {
 	if session.IsAutoClose {
 		defer session.Close()
 	}
+	defer session.useSlave()()
 
 	session.Statement.Limit(1)
 	var sql string
 	var args []interface{}
 	session.Statement.RefTable = session.Engine.autoMap(bean)
 	if session.Statement.RawSQL == "" {
-		sql, args = session.Statement.genGetSql(bean)
+		sql, args, err = session.Statement.genGetSql(bean)
+		if err != nil {
+			return false, err
+		}
 	} else {
 		sql = session.Statement.RawSQL
 		args = session.Statement.RawParams
 	}
 
-	if session.Statement.RefTable.Cacher != nil && session.Statement.UseCache {
+	if session.Statement.RefTable.Cacher != nil && session.Statement.UseCache &&
+		!session.Statement.hasSubQuery && !session.Statement.isExtendsJoin() {
 		has, err := session.cacheGet(bean, sql, args...)
 		if err != ErrCacheFailed {
 			return has, err
 		}
 	}
 
-	resultsSlice, err := session.query(sql, args...)
-	if err != nil {
-		return false, err
-	}
-	if len(resultsSlice) < 1 {
-		return false, nil
-	}
-
-	err = session.scanMapIntoStruct(bean, resultsSlice[0])
-	if err != nil {
-		return true, err
-	}
-	if len(resultsSlice) == 1 {
-		return true, nil
-	} else {
-		return true, errors.New("More than one record")
+	beanValue := reflect.ValueOf(bean)
+	if beanValue.Kind() != reflect.Ptr || beanValue.Elem().Kind() != reflect.Struct {
+		return false, errors.New("Expected a pointer to a struct")
 	}
+	var has bool
+	err = session.scanRows(session.Engine.autoMapType(beanValue.Elem().Type()), sql, args, func(row *resultRow) error {
+		if has {
+			return errors.New("More than one record")
+		}
+		has = true
+		return row.scan(beanValue)
+	})
+	return has, err
 }
 
 // Count counts the records. bean's non-empty fields
 // are conditions.
 func (session *Session) Count(bean interface{}) (int64, error) {
-	err := session.newDb()
+	shards, err := session.routeShard(rType(bean), bean)
+	if err != nil {
+		session.resetOp()
+		return 0, err
+	}
+	if shards != nil {
+		var total int64
+		err = session.eachShard(shards, func() error {
+			cnt, err := session.Count(bean)
+			total += cnt
+			return err
+		})
+		return total, err
+	}
+
+	err = session.newDb()
 	if err != nil {
 		return 0, err
 	}
@@ -850,11 +1173,15 @@ func (session *Session) Count(bean interface{}) (int64, error) {
 	if session.IsAutoClose {
 		defer session.Close()
 	}
+	defer session.useSlave()()
 
 	var sql string
 	var args []interface{}
 	if session.Statement.RawSQL == "" {
-		sql, args = session.Statement.genCountSql(bean)
+		sql, args, err = session.Statement.genCountSql(bean)
+		if err != nil {
+			return 0, err
+		}
 	} else {
 		sql = session.Statement.RawSQL
 		args = session.Statement.RawParams
@@ -881,7 +1208,58 @@ func (session *Session) Count(bean interface{}) (int64, error) {
 // are conditions. beans could be []Struct, []*Struct, map[int64]Struct
 // map[int64]*Struct
 func (session *Session) Find(rowsSlicePtr interface{}, condiBean ...interface{}) error {
-	err := session.newDb()
+	preloads := session.Statement.preloads
+	sliceValue := reflect.Indirect(reflect.ValueOf(rowsSlicePtr))
+	loader := (sliceValue.Kind() == reflect.Slice || sliceValue.Kind() == reflect.Map) &&
+		isLoader(sliceValue.Type())
+	if len(preloads) == 0 && !loader {
+		return session.find(rowsSlicePtr, condiBean...)
+	}
+	// the context and transaction should be kept before the session is reset
+	rel := session.relSession()
+	defer rel.Close()
+	loaded := 0
+	if sliceValue.Kind() == reflect.Slice {
+		loaded = sliceValue.Len()
+	}
+	err := session.find(rowsSlicePtr, condiBean...)
+	if err != nil {
+		return err
+	}
+	if len(preloads) > 0 {
+		if sliceValue.Kind() != reflect.Slice {
+			return errors.New("Preload needs a pointer to a slice")
+		}
+		beans := make([]reflect.Value, 0, sliceValue.Len())
+		for i := 0; i < sliceValue.Len(); i++ {
+			beans = append(beans, reflect.Indirect(sliceValue.Index(i)))
+		}
+		err = rel.preload(beans, preloads)
+		if err != nil {
+			return err
+		}
+	}
+	if loader {
+		// the records in the slice before Find are not loaded
+		if sliceValue.Kind() == reflect.Slice {
+			sliceValue = sliceValue.Slice(loaded, sliceValue.Len())
+		}
+		afterLoad(rel, sliceValue)
+	}
+	return nil
+}
+
+func (session *Session) find(rowsSlicePtr interface{}, condiBean ...interface{}) error {
+	shards, err := session.routeShard(reflect.TypeOf(rowsSlicePtr), condiBean...)
+	if err != nil {
+		session.resetOp()
+		return err
+	}
+	if shards != nil {
+		return session.findShards(shards, rowsSlicePtr, condiBean...)
+	}
+
+	err = session.newDb()
 	if err != nil {
 		return err
 	}
@@ -889,6 +1267,7 @@ func (session *Session) Find(rowsSlicePtr interface{}, condiBean ...interface{})
 	if session.IsAutoClose {
 		defer session.Close()
 	}
+	defer session.useSlave()()
 
 	sliceValue := reflect.Indirect(reflect.ValueOf(rowsSlicePtr))
 	if sliceValue.Kind() != reflect.Slice && sliceValue.Kind() != reflect.Map {
@@ -924,12 +1303,16 @@ func (session *Session) Find(rowsSlicePtr interface{}, condiBean ...interface{})
 	var sql string
 	var args []interface{}
 	if session.Statement.RawSQL == "" {
+		err = session.Statement.processIdParam()
+		if err != nil {
+			return err
+		}
 		var columnStr string = session.Statement.ColumnStr
 		if columnStr == "" {
 			columnStr = session.Statement.genColumnStr()
 		}
 		sql = session.Statement.genSelectSql(columnStr)
-		args = append(session.Statement.Params, session.Statement.BeanArgs...)
+		args = session.Statement.selectArgs()
 	} else {
 		sql = session.Statement.RawSQL
 		args = session.Statement.RawParams
@@ -937,7 +1320,9 @@ func (session *Session) Find(rowsSlicePtr interface{}, condiBean ...interface{})
 
 	if table.Cacher != nil &&
 		session.Statement.UseCache &&
-		!session.Statement.IsDistinct {
+		!session.Statement.IsDistinct &&
+		!session.Statement.hasSubQuery &&
+		!session.Statement.isExtendsJoin() {
 		err = session.cacheFind(sliceElementType, sql, rowsSlicePtr, args...)
 		if err != ErrCacheFailed {
 			return err
@@ -945,19 +1330,15 @@ func (session *Session) Find(rowsSlicePtr interface{}, condiBean ...interface{})
 		session.Engine.LogWarn("Cache Find Failed")
 	}
 
-	resultsSlice, err := session.query(sql, args...)
-	if err != nil {
-		return err
+	elemType := sliceElementType
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
 	}
-
-	for i, results := range resultsSlice {
-		var newValue reflect.Value
-		if sliceElementType.Kind() == reflect.Ptr {
-			newValue = reflect.New(sliceElementType.Elem())
-		} else {
-			newValue = reflect.New(sliceElementType)
-		}
-		err := session.scanMapIntoStruct(newValue.Interface(), results)
+	elemTable := session.Engine.autoMapType(elemType)
+	i := 0
+	return session.scanRows(elemTable, sql, args, func(row *resultRow) error {
+		newValue := reflect.New(elemType)
+		err := row.scan(newValue)
 		if err != nil {
 			return err
 		}
@@ -968,24 +1349,28 @@ func (session *Session) Find(rowsSlicePtr interface{}, condiBean ...interface{})
 				sliceValue.Set(reflect.Append(sliceValue, reflect.Indirect(reflect.ValueOf(newValue.Interface()))))
 			}
 		} else if sliceValue.Kind() == reflect.Map {
-			var key int64
-			if table.PrimaryKey != "" {
-				x, err := strconv.ParseInt(string(results[table.PrimaryKey]), 10, 64)
-				if err != nil {
-					return errors.New("pk " + table.PrimaryKey + " as int64: " + err.Error())
+			var pk PK
+			if len(elemTable.PrimaryKeys) > 0 {
+				if !row.plan.hasPks {
+					return errors.New("no id")
 				}
-				key = x
+				pk = elemTable.pkValues(newValue.Interface())
 			} else {
-				key = int64(i)
+				pk = PK{int64(i)}
+			}
+			key, err := pk2MapKey(pk, sliceValue.Type().Key())
+			if err != nil {
+				return err
 			}
 			if sliceElementType.Kind() == reflect.Ptr {
-				sliceValue.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(newValue.Interface()))
+				sliceValue.SetMapIndex(key, reflect.ValueOf(newValue.Interface()))
 			} else {
-				sliceValue.SetMapIndex(reflect.ValueOf(key), reflect.Indirect(reflect.ValueOf(newValue.Interface())))
+				sliceValue.SetMapIndex(key, reflect.Indirect(reflect.ValueOf(newValue.Interface())))
 			}
 		}
-	}
-	return nil
+		i++
+		return nil
+	})
 }
 
 // Test if database is ok
@@ -999,7 +1384,9 @@ func (session *Session) Ping() error {
 		defer session.Close()
 	}
 
-	return session.Db.Ping()
+	ctx, cancel := session.opContext()
+	defer cancel()
+	return session.Db.PingContext(ctx)
 }
 
 func (session *Session) isColumnExist(tableName, colName string) (bool, error) {
@@ -1154,59 +1541,16 @@ func row2map(rows *sql.Rows, fields []string) (resultsMap map[string][]byte, err
 	for ii, key := range fields {
 		rawValue := reflect.Indirect(reflect.ValueOf(scanResultContainers[ii]))
 
-		//if row is null then ignore
+		// a null column is kept as nil
 		if rawValue.Interface() == nil {
-			//fmt.Println("ignore ...", key, rawValue)
+			result[key] = nil
 			continue
 		}
-		aa := reflect.TypeOf(rawValue.Interface())
-		vv := reflect.ValueOf(rawValue.Interface())
-		var str string
-		switch aa.Kind() {
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			str = strconv.FormatInt(vv.Int(), 10)
-			result[key] = []byte(str)
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			str = strconv.FormatUint(vv.Uint(), 10)
-			result[key] = []byte(str)
-		case reflect.Float32, reflect.Float64:
-			str = strconv.FormatFloat(vv.Float(), 'f', -1, 64)
-			result[key] = []byte(str)
-		case reflect.String:
-			str = vv.String()
-			result[key] = []byte(str)
-		case reflect.Array, reflect.Slice:
-			switch aa.Elem().Kind() {
-			case reflect.Uint8:
-				result[key] = rawValue.Interface().([]byte)
-				str = string(result[key])
-			default:
-				return nil, errors.New(fmt.Sprintf("Unsupported struct type %v", vv.Type().Name()))
-			}
-		//时间类型
-		case reflect.Struct:
-			if aa.String() == "time.Time" {
-				str = rawValue.Interface().(time.Time).Format(time.RFC3339Nano)
-				result[key] = []byte(str)
-			} else {
-				return nil, errors.New(fmt.Sprintf("Unsupported struct type %v", vv.Type().Name()))
-			}
-		case reflect.Bool:
-			str = strconv.FormatBool(vv.Bool())
-			result[key] = []byte(str)
-		case reflect.Complex128, reflect.Complex64:
-			str = fmt.Sprintf("%v", vv.Complex())
-			result[key] = []byte(str)
-		/* TODO: unsupported types below
-		case reflect.Map:
-		case reflect.Ptr:
-		case reflect.Uintptr:
-		case reflect.UnsafePointer:
-		case reflect.Chan, reflect.Func, reflect.Interface:
-		*/
-		default:
-			return nil, errors.New(fmt.Sprintf("Unsupported struct type %v", vv.Type().Name()))
+		data, err := scannedBytes(rawValue.Interface())
+		if err != nil {
+			return nil, err
 		}
+		result[key] = data
 	}
 	return result, nil
 }
@@ -1224,16 +1568,38 @@ func rows2maps(rows *sql.Rows) (resultsSlice []map[string][]byte, err error) {
 		resultsSlice = append(resultsSlice, result)
 	}
 
-	return resultsSlice, nil
+	// the errors of an insert with RETURNING are reported when reading rows
+	return resultsSlice, rows.Err()
 }
 
 func query(db *sql.DB, sql string, params ...interface{}) (resultsSlice []map[string][]byte, err error) {
-	s, err := db.Prepare(sql)
+	return queryContext(context.Background(), db, sql, params...)
+}
+
+// sql.DB and sql.Tx both could prepare statements
+type preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// the queries in a transaction should be executed by the transaction, and
+// the reads of an engine group are executed by a slave
+func (session *Session) preparer() preparer {
+	if !session.IsAutoCommit && !session.IsCommitedOrRollbacked {
+		return session.Tx
+	}
+	if session.slaveDb != nil {
+		return session.slaveDb
+	}
+	return session.Db
+}
+
+func queryContext(ctx context.Context, db preparer, sql string, params ...interface{}) (resultsSlice []map[string][]byte, err error) {
+	s, err := db.PrepareContext(ctx, sql)
 	if err != nil {
 		return nil, err
 	}
 	defer s.Close()
-	rows, err := s.Query(params...)
+	rows, err := s.QueryContext(ctx, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -1250,7 +1616,20 @@ func (session *Session) query(sql string, paramStr ...interface{}) (resultsSlice
 	session.Engine.LogSQL(sql)
 	session.Engine.LogSQL(paramStr)
 
-	return query(session.Db, sql, paramStr...)
+	ctx, cancel := session.opContext()
+	defer cancel()
+	s, release, err := session.prepare(ctx, session.preparer(), sql)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	rows, err := s.QueryContext(ctx, paramStr...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return rows2maps(rows)
 }
 
 // Exec a raw sql and return records as []map[string][]byte
@@ -1263,6 +1642,7 @@ func (session *Session) Query(sql string, paramStr ...interface{}) (resultsSlice
 	if session.IsAutoClose {
 		defer session.Close()
 	}
+	defer session.useSlave()()
 
 	return session.query(sql, paramStr...)
 }
@@ -1290,9 +1670,8 @@ func (session *Session) Insert(beans ...interface{}) (int64, error) {
 				}
 				affected += cnt
 			} else {
-				size := sliceValue.Len()
-				for i := 0; i < size; i++ {
-					cnt, err := session.innerInsert(sliceValue.Index(i).Interface())
+				for _, elem := range sliceBeans(sliceValue) {
+					cnt, err := session.innerInsert(elem)
 					if err != nil {
 						return affected, err
 					}
@@ -1317,12 +1696,101 @@ func (session *Session) innerInsertMulti(rowsSlicePtr interface{}) (int64, error
 		return 0, errors.New("needs a pointer to a slice")
 	}
 
+	beans := sliceBeans(sliceValue)
+	afterClosures := session.afterClosures
+	for _, bean := range beans {
+		session.before(hookInsert, bean)
+	}
+	affected, err := session.insertMulti(sliceValue)
+	if err == nil {
+		for _, bean := range beans {
+			after(afterClosures, hookInsert, bean)
+		}
+	}
+	return affected, err
+}
+
+func (session *Session) insertMulti(sliceValue reflect.Value) (int64, error) {
 	bean := sliceValue.Index(0).Interface()
 	sliceElementType := rType(bean)
 
 	table := session.Engine.autoMapType(sliceElementType)
 	session.Statement.RefTable = table
 
+	if table.shard != nil && session.Statement.AltTableName == "" {
+		return session.insertMultiShards(table, sliceValue)
+	}
+	for _, elem := range sliceBeans(sliceValue) {
+		initVersion(table, elem)
+	}
+
+	// every chunk is inserted by one statement, the number of the arguments
+	// of a statement is limited by the database
+	size := sliceValue.Len()
+	chunk := size
+	if maxArgs := session.Engine.dialect.MaxInsertArgs(); maxArgs > 0 && len(table.Columns) > 0 {
+		chunk = maxArgs / len(table.Columns)
+		if chunk < 1 {
+			chunk = 1
+		}
+	}
+
+	// the ids generated by a rollbacked transaction are reset, so the beans
+	// are inserted again without the ids when the transaction is retried
+	generated := make([]reflect.Value, 0)
+	if table.AutoIncrement != "" {
+		for _, elem := range sliceBeans(sliceValue) {
+			pkValue := table.AutoIncrColumn().ValueOf(elem)
+			if pkValue.IsValid() && pkValue.CanSet() && isZero(pkValue) {
+				generated = append(generated, pkValue)
+			}
+		}
+	}
+	resetIds := func() {
+		for _, pkValue := range generated {
+			pkValue.Set(reflect.Zero(pkValue.Type()))
+		}
+	}
+
+	var affected int64
+	insertChunks := func(*Session) error {
+		affected = 0
+		resetIds()
+		for start := 0; start < size; start += chunk {
+			end := start + chunk
+			if end > size {
+				end = size
+			}
+			cnt, err := session.insertChunk(table, sliceValue.Slice(start, end))
+			affected += cnt
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if session.Statement.chunksInTx && size > chunk {
+		err = session.Transaction(insertChunks)
+		if err != nil {
+			affected = 0
+			resetIds()
+		}
+	} else {
+		err = insertChunks(session)
+	}
+
+	if table.Cacher != nil && session.Statement.UseCache {
+		session.cacheInsert(session.Statement.TableName())
+	}
+
+	return affected, err
+}
+
+// insert the beans of the slice by one statement, and the generated ids are
+// set to the beans whose ids are zero
+func (session *Session) insertChunk(table *Table, sliceValue reflect.Value) (int64, error) {
 	size := sliceValue.Len()
 
 	colNames := make([]string, 0)
@@ -1337,7 +1805,7 @@ func (session *Session) innerInsertMulti(rowsSlicePtr interface{}) (int64, error
 		if i == 0 {
 			for _, col := range table.Columns {
 				fieldValue := reflect.Indirect(reflect.ValueOf(elemValue)).FieldByName(col.FieldName)
-				if col.IsAutoIncrement && fieldValue.Int() == 0 {
+				if (col.IsAutoIncrement || col.IsDeleted) && isZero(fieldValue) {
 					continue
 				}
 				if col.MapType == ONLYFROMDB {
@@ -1349,7 +1817,7 @@ func (session *Session) innerInsertMulti(rowsSlicePtr interface{}) (int64, error
 					}
 				}
 				if (col.IsCreated || col.IsUpdated) && session.Statement.UseAutoTime {
-					args = append(args, time.Now())
+					args = append(args, session.Engine.nowTime(col))
 				} else {
 					arg, err := session.value2Interface(col, fieldValue)
 					if err != nil {
@@ -1365,7 +1833,7 @@ func (session *Session) innerInsertMulti(rowsSlicePtr interface{}) (int64, error
 		} else {
 			for _, col := range cols {
 				fieldValue := reflect.Indirect(reflect.ValueOf(elemValue)).FieldByName(col.FieldName)
-				if col.IsAutoIncrement && fieldValue.Int() == 0 {
+				if col.IsAutoIncrement && isZero(fieldValue) {
 					continue
 				}
 				if col.MapType == ONLYFROMDB {
@@ -1377,7 +1845,7 @@ func (session *Session) innerInsertMulti(rowsSlicePtr interface{}) (int64, error
 					}
 				}
 				if (col.IsCreated || col.IsUpdated) && session.Statement.UseAutoTime {
-					args = append(args, time.Now())
+					args = append(args, session.Engine.nowTime(col))
 				} else {
 					arg, err := session.value2Interface(col, fieldValue)
 					if err != nil {
@@ -1401,13 +1869,53 @@ func (session *Session) innerInsertMulti(rowsSlicePtr interface{}) (int64, error
 		session.Engine.QuoteStr(),
 		strings.Join(colMultiPlaces, "),("))
 
+	if table.AutoIncrement != "" && session.Engine.dialect.SupportReturning() {
+		statement = statement + " RETURNING " + session.Engine.Quote(table.AutoIncrement)
+		res, err := session.query(statement, args...)
+		if err != nil {
+			return 0, err
+		}
+		for i, bean := range sliceBeans(sliceValue) {
+			if i >= len(res) {
+				break
+			}
+			pkValue := table.AutoIncrColumn().ValueOf(bean)
+			if !pkValue.IsValid() || !isZero(pkValue) || !pkValue.CanSet() {
+				continue
+			}
+			id, err := strconv.ParseInt(string(res[i][table.AutoIncrement]), 10, 64)
+			if err != nil {
+				return int64(len(res)), err
+			}
+			setIntId(pkValue, id)
+		}
+		return int64(len(res)), nil
+	}
+
 	res, err := session.exec(statement, args...)
 	if err != nil {
 		return 0, err
 	}
 
-	if table.Cacher != nil && session.Statement.UseCache {
-		session.cacheInsert(session.Statement.TableName())
+	// the ids are consecutive from the first id of the inserted records when
+	// all of them are generated
+	if table.AutoIncrement != "" && session.Engine.dialect.ConsecutiveInsertIds() {
+		for _, col := range cols {
+			if col.IsAutoIncrement {
+				return res.RowsAffected()
+			}
+		}
+		id, err := res.LastInsertId()
+		if err != nil || id <= 0 {
+			return res.RowsAffected()
+		}
+		for _, bean := range sliceBeans(sliceValue) {
+			pkValue := table.AutoIncrColumn().ValueOf(bean)
+			if pkValue.IsValid() && pkValue.CanSet() {
+				setIntId(pkValue, id)
+			}
+			id++
+		}
 	}
 
 	return res.RowsAffected()
@@ -1432,6 +1940,23 @@ func (session *Session) bytes2Value(col *Column, fieldValue *reflect.Value, data
 	if structConvert, ok := fieldValue.Addr().Interface().(Conversion); ok {
 		return structConvert.FromDB(data)
 	}
+	if c, ok := session.Engine.converterOf(fieldValue.Type()); ok {
+		return c.set(*fieldValue, data)
+	}
+	if scanner, ok := fieldValue.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(data)
+	}
+	// a pointer is set to a new value which is converted from the data
+	if fieldValue.Kind() == reflect.Ptr {
+		x := reflect.New(fieldValue.Type().Elem())
+		elem := x.Elem()
+		err := session.bytes2Value(col, &elem, data)
+		if err != nil {
+			return err
+		}
+		fieldValue.Set(x)
+		return nil
+	}
 
 	var v interface{}
 	key := col.Name
@@ -1497,9 +2022,7 @@ func (session *Session) bytes2Value(col *Column, fieldValue *reflect.Value, data
 			}
 			//fmt.Println("######", x, data)
 		} else if strings.HasPrefix(sdata, "0x") {
-			x, err = strconv.ParseInt(sdata, 16, 64)
-		} else if strings.HasPrefix(sdata, "0") {
-			x, err = strconv.ParseInt(sdata, 8, 64)
+			x, err = strconv.ParseInt(sdata[2:], 16, 64)
 		} else {
 			x, err = strconv.ParseInt(sdata, 10, 64)
 		}
@@ -1522,32 +2045,9 @@ func (session *Session) bytes2Value(col *Column, fieldValue *reflect.Value, data
 	//Now only support Time type
 	case reflect.Struct:
 		if fieldValue.Type().String() == "time.Time" {
-			sdata := string(data)
-			var x time.Time
-			var err error
-			// time stamp
-			if !strings.ContainsAny(sdata, "- :") {
-				sd, err := strconv.ParseInt(sdata, 10, 64)
-				if err == nil {
-					x = time.Unix(0, sd)
-				}
-			} else if len(sdata) > 19 {
-				x, err = time.Parse(time.RFC3339Nano, sdata)
-			} else if len(sdata) == 19 {
-				x, err = time.Parse("2006-01-02 15:04:05", sdata)
-			} else if len(sdata) == 10 && sdata[4] == '-' && sdata[7] == '-' {
-				x, err = time.Parse("2006-01-02", sdata)
-			} else if col.SQLType.Name == Time {
-				if len(sdata) > 8 {
-					sdata = sdata[len(sdata)-8:]
-				}
-				st := fmt.Sprintf("2006-01-02 %v", sdata)
-				x, err = time.Parse("2006-01-02 15:04:05", st)
-			} else {
-				return errors.New(fmt.Sprintf("unsupported time format %v", string(data)))
-			}
+			x, err := session.Engine.parseTime(col, string(data))
 			if err != nil {
-				return errors.New(fmt.Sprintf("unsupported time format %v: %v", string(data), err))
+				return err
 			}
 
 			v = x
@@ -1555,15 +2055,20 @@ func (session *Session) bytes2Value(col *Column, fieldValue *reflect.Value, data
 		} else if session.Statement.UseCascade {
 			table := session.Engine.autoMapType(fieldValue.Type())
 			if table != nil {
-				x, err := strconv.ParseInt(string(data), 10, 64)
+				if len(table.PrimaryKeys) != 1 {
+					return errors.New("arg " + key + " cascade needs one primary key")
+				}
+				structInter := reflect.New(fieldValue.Type())
+				pkCol := table.PKColumns()[0]
+				pkValue := pkCol.ValueOf(structInter.Interface())
+				err := session.bytes2Value(pkCol, &pkValue, data)
 				if err != nil {
-					return errors.New("arg " + key + " as int: " + err.Error())
+					return err
 				}
-				if x != 0 {
-					structInter := reflect.New(fieldValue.Type())
+				if !isZero(pkValue) {
 					newsession := session.Engine.NewSession()
 					defer newsession.Close()
-					has, err := newsession.Id(x).Get(structInter.Interface())
+					has, err := newsession.Id(pkValue.Interface()).Get(structInter.Interface())
 					if err != nil {
 						return err
 					}
@@ -1597,6 +2102,24 @@ func (session *Session) value2Interface(col *Column, fieldValue reflect.Value) (
 			}
 		}
 	}
+	if c, ok := session.Engine.converterOf(fieldValue.Type()); ok {
+		return c.value(col, fieldValue)
+	}
+	// a nil pointer is null, and a pointer is converted as what it points to
+	if fieldValue.Kind() == reflect.Ptr {
+		if fieldValue.IsNil() {
+			return nil, nil
+		}
+		return session.value2Interface(col, fieldValue.Elem())
+	}
+	if valuer, ok := fieldValue.Interface().(driver.Valuer); ok {
+		return valuer.Value()
+	}
+	if fieldValue.CanAddr() {
+		if valuer, ok := fieldValue.Addr().Interface().(driver.Valuer); ok {
+			return valuer.Value()
+		}
+	}
 
 	k := fieldValue.Type().Kind()
 	switch k {
@@ -1610,21 +2133,14 @@ func (session *Session) value2Interface(col *Column, fieldValue reflect.Value) (
 		return fieldValue.String(), nil
 	case reflect.Struct:
 		if fieldValue.Type().String() == "time.Time" {
-			if col.SQLType.Name == Time {
-				//s := fieldValue.Interface().(time.Time).Format("2006-01-02 15:04:05 -0700")
-				s := fieldValue.Interface().(time.Time).Format(time.RFC3339)
-				return s[11:19], nil
-			} else if col.SQLType.Name == Date {
-				return fieldValue.Interface().(time.Time).Format("2006-01-02"), nil
-			}
-			return fieldValue.Interface(), nil
+			return session.Engine.formatTime(col, fieldValue.Interface().(time.Time)), nil
 		}
 		if fieldTable, ok := session.Engine.Tables[fieldValue.Type()]; ok {
-			if fieldTable.PrimaryKey != "" {
-				pkField := reflect.Indirect(fieldValue).FieldByName(fieldTable.PKColumn().FieldName)
+			if len(fieldTable.PrimaryKeys) == 1 {
+				pkField := reflect.Indirect(fieldValue).FieldByName(fieldTable.PKColumns()[0].FieldName)
 				return pkField.Interface(), nil
 			} else {
-				return 0, errors.New("no primary key")
+				return 0, errors.New("no primary key or composite primary keys")
 			}
 		} else {
 			return 0, errors.New(fmt.Sprintf("Unsupported type %v", fieldValue.Type()))
@@ -1671,8 +2187,30 @@ func (session *Session) value2Interface(col *Column, fieldValue reflect.Value) (
 }
 
 func (session *Session) innerInsert(bean interface{}) (int64, error) {
+	afterClosures := session.afterClosures
+	session.before(hookInsert, bean)
+	affected, err := session.insertBean(bean)
+	if err == nil {
+		after(afterClosures, hookInsert, bean)
+	}
+	return affected, err
+}
+
+func (session *Session) insertBean(bean interface{}) (int64, error) {
 	table := session.Engine.autoMap(bean)
 	session.Statement.RefTable = table
+	initVersion(table, bean)
+
+	name, err := session.insertShard(table, bean)
+	if err != nil {
+		return 0, err
+	}
+	if name != "" {
+		session.Statement.AltTableName = name
+		defer func() {
+			session.Statement.AltTableName = ""
+		}()
+	}
 
 	colNames, args, err := table.genCols(session, bean, false, false)
 	if err != nil {
@@ -1693,7 +2231,7 @@ func (session *Session) innerInsert(bean interface{}) (int64, error) {
 
 	// for postgres, many of them didn't implement lastInsertId, so we should
 	// implemented it ourself.
-	if session.Engine.DriverName != POSTGRES || table.PrimaryKey == "" {
+	if session.Engine.DriverName != POSTGRES || table.AutoIncrement == "" {
 		res, err := session.exec(sql, args...)
 		if err != nil {
 			return 0, err
@@ -1703,7 +2241,7 @@ func (session *Session) innerInsert(bean interface{}) (int64, error) {
 			session.cacheInsert(session.Statement.TableName())
 		}
 
-		if table.PrimaryKey == "" {
+		if table.AutoIncrement == "" {
 			return res.RowsAffected()
 		}
 
@@ -1713,23 +2251,16 @@ func (session *Session) innerInsert(bean interface{}) (int64, error) {
 			return res.RowsAffected()
 		}
 
-		pkValue := table.PKColumn().ValueOf(bean)
-		if !pkValue.IsValid() || pkValue.Int() != 0 || !pkValue.CanSet() {
+		pkValue := table.AutoIncrColumn().ValueOf(bean)
+		if !pkValue.IsValid() || !isZero(pkValue) || !pkValue.CanSet() {
 			return res.RowsAffected()
 		}
 
-		var v interface{} = id
-		switch pkValue.Type().Kind() {
-		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int:
-			v = int(id)
-		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
-			v = uint(id)
-		}
-		pkValue.Set(reflect.ValueOf(v))
+		setIntId(pkValue, id)
 
 		return res.RowsAffected()
 	} else {
-		sql = sql + " RETURNING (id)"
+		sql = sql + " RETURNING " + session.Engine.Quote(table.AutoIncrement)
 		res, err := session.query(sql, args...)
 		if err != nil {
 			return 0, err
@@ -1743,25 +2274,18 @@ func (session *Session) innerInsert(bean interface{}) (int64, error) {
 			return 0, errors.New("insert no error but not returned id")
 		}
 
-		idByte := res[0][table.PrimaryKey]
+		idByte := res[0][table.AutoIncrement]
 		id, err := strconv.ParseInt(string(idByte), 10, 64)
 		if err != nil {
 			return 1, err
 		}
 
-		pkValue := table.PKColumn().ValueOf(bean)
-		if !pkValue.IsValid() || pkValue.Int() != 0 || !pkValue.CanSet() {
+		pkValue := table.AutoIncrColumn().ValueOf(bean)
+		if !pkValue.IsValid() || !isZero(pkValue) || !pkValue.CanSet() {
 			return 1, nil
 		}
 
-		var v interface{} = id
-		switch pkValue.Type().Kind() {
-		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int:
-			v = int(id)
-		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
-			v = uint(id)
-		}
-		pkValue.Set(reflect.ValueOf(v))
+		setIntId(pkValue, id)
 
 		return 1, nil
 	}
@@ -1784,15 +2308,16 @@ func (session *Session) InsertOne(bean interface{}) (int64, error) {
 }
 
 func (statement *Statement) convertUpdateSql(sql string) (string, string) {
-	if statement.RefTable == nil || statement.RefTable.PrimaryKey == "" {
+	if statement.RefTable == nil || len(statement.RefTable.PrimaryKeys) == 0 {
 		return "", ""
 	}
+	quote := statement.Engine.Quote
+	pkStr := quote(strings.Join(statement.RefTable.PrimaryKeys, quote(", ")))
 	sqls := splitNNoCase(sql, "where", 2)
 	if len(sqls) != 2 {
 		if len(sqls) == 1 {
 			return sqls[0], fmt.Sprintf("SELECT %v FROM %v",
-				statement.Engine.Quote(statement.RefTable.PrimaryKey),
-				statement.Engine.Quote(statement.RefTable.Name))
+				pkStr, quote(statement.RefTable.Name))
 		}
 		return "", ""
 	}
@@ -1810,12 +2335,11 @@ func (statement *Statement) convertUpdateSql(sql string) (string, string) {
 	}
 
 	return sqls[0], fmt.Sprintf("SELECT %v FROM %v WHERE %v",
-		statement.Engine.Quote(statement.RefTable.PrimaryKey), statement.Engine.Quote(statement.TableName()),
-		whereStr)
+		pkStr, quote(statement.TableName()), whereStr)
 }
 
 func (session *Session) cacheInsert(tables ...string) error {
-	if session.Statement.RefTable == nil || session.Statement.RefTable.PrimaryKey == "" {
+	if session.Statement.RefTable == nil || len(session.Statement.RefTable.PrimaryKeys) == 0 {
 		return ErrCacheFailed
 	}
 
@@ -1831,7 +2355,7 @@ func (session *Session) cacheInsert(tables ...string) error {
 }
 
 func (session *Session) cacheUpdate(sql string, args ...interface{}) error {
-	if session.Statement.RefTable == nil || session.Statement.RefTable.PrimaryKey == "" {
+	if session.Statement.RefTable == nil || len(session.Statement.RefTable.PrimaryKeys) == 0 {
 		return ErrCacheFailed
 	}
 
@@ -1865,20 +2389,9 @@ func (session *Session) cacheUpdate(sql string, args ...interface{}) error {
 		}
 		session.Engine.LogDebug("[xorm:cacheUpdate] find updated id", resultsSlice)
 
-		ids = make([]int64, 0)
-		if len(resultsSlice) > 0 {
-			for _, data := range resultsSlice {
-				var id int64
-				if v, ok := data[session.Statement.RefTable.PrimaryKey]; !ok {
-					return errors.New("no id")
-				} else {
-					id, err = strconv.ParseInt(string(v), 10, 64)
-					if err != nil {
-						return err
-					}
-				}
-				ids = append(ids, id)
-			}
+		ids, err = session.records2PKs(table, resultsSlice)
+		if err != nil {
+			return err
 		}
 	} /*else {
 		session.Engine.LogDebug("[xorm:cacheUpdate] del cached sql:", tableName, newsql, args)
@@ -1886,7 +2399,12 @@ func (session *Session) cacheUpdate(sql string, args ...interface{}) error {
 	}*/
 
 	for _, id := range ids {
-		if bean := cacher.GetBean(tableName, id); bean != nil {
+		sid, err := id.ToString()
+		if err != nil {
+			return err
+		}
+		if bean := cacher.GetBean(tableName, sid); bean != nil {
+			staled := false
 			sqls := splitNNoCase(sql, "where", 2)
 			if len(sqls) == 0 || len(sqls) > 2 {
 				return ErrCacheFailed
@@ -1913,15 +2431,23 @@ func (session *Session) cacheUpdate(sql string, args ...interface{}) error {
 				if col, ok := table.Columns[colName]; ok {
 					fieldValue := col.ValueOf(bean)
 					session.Engine.LogDebug("[xorm:cacheUpdate] set bean field", bean, colName, fieldValue.Interface())
-					fieldValue.Set(reflect.ValueOf(args[idx]))
+					if err := setFieldValue(fieldValue, args[idx]); err != nil {
+						session.Engine.LogDebug("[xorm:cacheUpdate] cannot set bean field", colName, err)
+						staled = true
+					}
 				} else {
 					session.Engine.LogError("[xorm:cacheUpdate] ERROR: column %v is not table %v's",
 						colName, table.Name)
 				}
 			}
 
-			session.Engine.LogDebug("[xorm:cacheUpdate] update cache", tableName, id, bean)
-			cacher.PutBean(tableName, id, bean)
+			if staled {
+				session.Engine.LogDebug("[xorm:cacheUpdate] delete cache obj", tableName, sid)
+				cacher.DelBean(tableName, sid)
+				continue
+			}
+			session.Engine.LogDebug("[xorm:cacheUpdate] update cache", tableName, sid, bean)
+			cacher.PutBean(tableName, sid, bean)
 		}
 	}
 	session.Engine.LogDebug("[xorm:cacheUpdate] clear cached table sql:", tableName)
@@ -1936,7 +2462,84 @@ func (session *Session) cacheUpdate(sql string, args ...interface{}) error {
 // 		You should call UseBool if you have bool to use.
 //		2.float32 & float64 may be not inexact as conditions
 func (session *Session) Update(bean interface{}, condiBean ...interface{}) (int64, error) {
-	err := session.newDb()
+	afterClosures := session.afterClosures
+	session.before(hookUpdate, bean)
+	version := session.lockVersion(bean)
+	if version.IsValid() && isZero(version) {
+		session.resetOp()
+		return 0, ErrZeroVersion
+	}
+	affected, err := session.update(bean, condiBean...)
+	if err == nil && version.IsValid() {
+		if affected == 0 {
+			err = ErrOptimisticLock
+		} else if version.CanSet() {
+			incrVersion(version)
+		}
+	}
+	if err == nil {
+		after(afterClosures, hookUpdate, bean)
+	}
+	return affected, err
+}
+
+// the version field of the bean when the table has a version column, the
+// version should be matched when updating, and it can't be zero
+func (session *Session) lockVersion(bean interface{}) reflect.Value {
+	if rType(bean).Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	table := session.Engine.autoMap(bean)
+	if table.Version == "" {
+		return reflect.Value{}
+	}
+	version := table.Columns[table.Version].ValueOf(bean)
+	if !version.IsValid() || !isIntKind(version.Type()) {
+		return reflect.Value{}
+	}
+	return version
+}
+
+// the version of a new record starts from 1
+func initVersion(table *Table, bean interface{}) {
+	if table.Version == "" {
+		return
+	}
+	version := table.Columns[table.Version].ValueOf(bean)
+	if version.CanSet() && isIntKind(version.Type()) && isZero(version) {
+		setIntId(version, 1)
+	}
+}
+
+// the updated version is increased by 1
+func incrVersion(version reflect.Value) {
+	switch version.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		version.SetInt(version.Int() + 1)
+	default:
+		version.SetUint(version.Uint() + 1)
+	}
+}
+
+func (session *Session) update(bean interface{}, condiBean ...interface{}) (int64, error) {
+	// the shard key can't be changed, so the bean could be routed too
+	keyBeans := append([]interface{}{}, condiBean...)
+	shards, err := session.routeShard(rType(bean), append(keyBeans, bean)...)
+	if err != nil {
+		session.resetOp()
+		return 0, err
+	}
+	if shards != nil {
+		var affected int64
+		err = session.eachShard(shards, func() error {
+			cnt, err := session.update(bean, condiBean...)
+			affected += cnt
+			return err
+		})
+		return affected, err
+	}
+
+	err = session.newDb()
 	if err != nil {
 		return 0, err
 	}
@@ -1983,7 +2586,7 @@ func (session *Session) Update(bean interface{}, condiBean ...interface{}) (int6
 
 	if session.Statement.UseAutoTime && table.Updated != "" {
 		colNames = append(colNames, session.Engine.Quote(table.Updated)+" = ?")
-		args = append(args, time.Now())
+		args = append(args, session.Engine.nowTime(table.Columns[table.Updated]))
 	}
 
 	var condiColNames []string
@@ -1993,6 +2596,15 @@ func (session *Session) Update(bean interface{}, condiBean ...interface{}) (int6
 		condiColNames, condiArgs = buildConditions(session.Engine, session.Statement.RefTable, condiBean[0], true,
 			session.Statement.allUseBool, session.Statement.boolColumnMap)
 	}
+	if version := session.lockVersion(bean); version.IsValid() {
+		condiColNames = append(condiColNames, session.Engine.Quote(table.Version)+" = ?")
+		condiArgs = append(condiArgs, version.Interface())
+	}
+
+	err = session.Statement.processIdParam()
+	if err != nil {
+		return 0, err
+	}
 
 	var condition = ""
 	st := session.Statement
@@ -2032,14 +2644,22 @@ func (session *Session) Update(bean interface{}, condiBean ...interface{}) (int6
 		return 0, err
 	}
 	if table.Cacher != nil && session.Statement.UseCache {
-		session.cacheUpdate(sql, args...)
+		if table.Version != "" {
+			// the increased versions can't be set to the cached beans
+			tableName := session.Statement.TableName()
+			session.Engine.LogDebug("[xorm:cacheUpdate] clear cached versioned table:", tableName)
+			table.Cacher.ClearBeans(tableName)
+			table.Cacher.ClearIds(tableName)
+		} else {
+			session.cacheUpdate(sql, args...)
+		}
 	}
 
 	return res.RowsAffected()
 }
 
 func (session *Session) cacheDelete(sql string, args ...interface{}) error {
-	if session.Statement.RefTable == nil || session.Statement.RefTable.PrimaryKey == "" {
+	if session.Statement.RefTable == nil || len(session.Statement.RefTable.PrimaryKeys) == 0 {
 		return ErrCacheFailed
 	}
 
@@ -2060,20 +2680,9 @@ func (session *Session) cacheDelete(sql string, args ...interface{}) error {
 		if err != nil {
 			return err
 		}
-		ids = make([]int64, 0)
-		if len(resultsSlice) > 0 {
-			for _, data := range resultsSlice {
-				var id int64
-				if v, ok := data[session.Statement.RefTable.PrimaryKey]; !ok {
-					return errors.New("no id")
-				} else {
-					id, err = strconv.ParseInt(string(v), 10, 64)
-					if err != nil {
-						return err
-					}
-				}
-				ids = append(ids, id)
-			}
+		ids, err = session.records2PKs(session.Statement.RefTable, resultsSlice)
+		if err != nil {
+			return err
 		}
 	} /*else {
 		session.Engine.LogDebug("delete cache sql %v", newsql)
@@ -2081,8 +2690,12 @@ func (session *Session) cacheDelete(sql string, args ...interface{}) error {
 	}*/
 
 	for _, id := range ids {
-		session.Engine.LogDebug("[xorm:cacheDelete] delete cache obj", tableName, id)
-		cacher.DelBean(tableName, id)
+		sid, err := id.ToString()
+		if err != nil {
+			return err
+		}
+		session.Engine.LogDebug("[xorm:cacheDelete] delete cache obj", tableName, sid)
+		cacher.DelBean(tableName, sid)
 	}
 	session.Engine.LogDebug("[xorm:cacheDelete] clear cache table", tableName)
 	cacher.ClearIds(tableName)
@@ -2091,7 +2704,32 @@ func (session *Session) cacheDelete(sql string, args ...interface{}) error {
 
 // Delete records, bean's non-empty fields are conditions
 func (session *Session) Delete(bean interface{}) (int64, error) {
-	err := session.newDb()
+	afterClosures := session.afterClosures
+	session.before(hookDelete, bean)
+	affected, err := session.delete(bean)
+	if err == nil {
+		after(afterClosures, hookDelete, bean)
+	}
+	return affected, err
+}
+
+func (session *Session) delete(bean interface{}) (int64, error) {
+	shards, err := session.routeShard(rType(bean), bean)
+	if err != nil {
+		session.resetOp()
+		return 0, err
+	}
+	if shards != nil {
+		var affected int64
+		err = session.eachShard(shards, func() error {
+			cnt, err := session.delete(bean)
+			affected += cnt
+			return err
+		})
+		return affected, err
+	}
+
+	err = session.newDb()
 	if err != nil {
 		return 0, err
 	}
@@ -2102,6 +2740,10 @@ func (session *Session) Delete(bean interface{}) (int64, error) {
 
 	table := session.Engine.autoMap(bean)
 	session.Statement.RefTable = table
+	err = session.Statement.processIdParam()
+	if err != nil {
+		return 0, err
+	}
 	colNames, args := buildConditions(session.Engine, table, bean, true,
 		session.Statement.allUseBool, session.Statement.boolColumnMap)
 
@@ -2127,6 +2769,14 @@ func (session *Session) Delete(bean interface{}) (int64, error) {
 		session.cacheDelete(sql, args...)
 	}
 
+	// soft delete sets the deleted time of the records not deleted
+	if table.Deleted != "" && !session.Statement.unscoped {
+		deleted := session.Engine.Quote(table.Deleted)
+		sql = fmt.Sprintf("UPDATE %v SET %v = ? WHERE (%v) AND %v IS NULL",
+			session.Engine.Quote(session.Statement.TableName()), deleted, condition, deleted)
+		args = append([]interface{}{session.Engine.nowTime(table.Columns[table.Deleted])}, args...)
+	}
+
 	res, err := session.exec(sql, args...)
 	if err != nil {
 		return 0, err
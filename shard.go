@@ -0,0 +1,479 @@
+package xorm
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ShardRule splits a table into the shard tables named like "orders_00" by
+// the value of the shard key column
+type ShardRule interface {
+	// Column returns the name of the shard key column
+	Column() string
+	// Suffix returns the suffix of the shard table where the key is in
+	Suffix(key interface{}) (string, error)
+	// Suffixes returns the suffixes of all the shard tables
+	Suffixes() []string
+}
+
+func shardInt(key interface{}) (int64, error) {
+	v := reflect.Indirect(reflect.ValueOf(key))
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), nil
+	case reflect.String:
+		return strconv.ParseInt(v.String(), 10, 64)
+	}
+	return 0, errors.New(fmt.Sprintf("unsupported shard key %v", key))
+}
+
+// the width of the numbers 0 to n-1 for the suffixes
+func suffixWidth(n int) int {
+	return len(strconv.Itoa(n - 1))
+}
+
+type hashShard struct {
+	column string
+	count  int
+}
+
+// HashShard splits a table into count shards by the hash of the column, an
+// integer key's hash is itself and a string key's hash is its fnv-1a. The
+// suffixes are numbers like 00 to 63.
+func HashShard(column string, count int) ShardRule {
+	return &hashShard{column, count}
+}
+
+func (rule *hashShard) Column() string {
+	return rule.column
+}
+
+func (rule *hashShard) Suffix(key interface{}) (string, error) {
+	var n uint64
+	if s, ok := reflect.Indirect(reflect.ValueOf(key)).Interface().(string); ok {
+		h := fnv.New32a()
+		h.Write([]byte(s))
+		n = uint64(h.Sum32())
+	} else {
+		i, err := shardInt(key)
+		if err != nil {
+			return "", err
+		}
+		if i < 0 {
+			i = -i
+		}
+		n = uint64(i)
+	}
+	return fmt.Sprintf("%0*d", suffixWidth(rule.count), n%uint64(rule.count)), nil
+}
+
+func (rule *hashShard) Suffixes() []string {
+	suffixes := make([]string, rule.count)
+	for i := range suffixes {
+		suffixes[i] = fmt.Sprintf("%0*d", suffixWidth(rule.count), i)
+	}
+	return suffixes
+}
+
+type rangeShard struct {
+	column string
+	bounds []int64
+}
+
+// RangeShard splits a table by the ascending bounds of the integer column,
+// there are len(bounds)+1 shards, the shard i holds the keys in
+// [bounds[i-1], bounds[i]). The suffixes are numbers like 0 to 3.
+func RangeShard(column string, bounds ...int64) ShardRule {
+	return &rangeShard{column, bounds}
+}
+
+func (rule *rangeShard) Column() string {
+	return rule.column
+}
+
+func (rule *rangeShard) Suffix(key interface{}) (string, error) {
+	i, err := shardInt(key)
+	if err != nil {
+		return "", err
+	}
+	idx := sort.Search(len(rule.bounds), func(n int) bool {
+		return rule.bounds[n] > i
+	})
+	return fmt.Sprintf("%0*d", suffixWidth(len(rule.bounds)+1), idx), nil
+}
+
+func (rule *rangeShard) Suffixes() []string {
+	suffixes := make([]string, len(rule.bounds)+1)
+	for i := range suffixes {
+		suffixes[i] = fmt.Sprintf("%0*d", suffixWidth(len(suffixes)), i)
+	}
+	return suffixes
+}
+
+type monthShard struct {
+	column   string
+	from, to time.Time
+}
+
+// MonthShard splits a table by the month of the time column, the months are
+// from the month of from to the month of to. The suffixes are like 201401.
+func MonthShard(column string, from, to time.Time) ShardRule {
+	return &monthShard{column,
+		time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC),
+		time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (rule *monthShard) Column() string {
+	return rule.column
+}
+
+func (rule *monthShard) Suffix(key interface{}) (string, error) {
+	t, ok := reflect.Indirect(reflect.ValueOf(key)).Interface().(time.Time)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("unsupported shard key %v", key))
+	}
+	month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if month.Before(rule.from) || month.After(rule.to) {
+		return "", errors.New(fmt.Sprintf("no shard for %v", t))
+	}
+	return month.Format("200601"), nil
+}
+
+func (rule *monthShard) Suffixes() []string {
+	suffixes := make([]string, 0)
+	for month := rule.from; !month.After(rule.to); month = month.AddDate(0, 1, 0) {
+		suffixes = append(suffixes, month.Format("200601"))
+	}
+	return suffixes
+}
+
+// the shard table of the key
+func (table *Table) shardTable(key interface{}) (string, error) {
+	suffix, err := table.shard.Suffix(key)
+	if err != nil {
+		return "", err
+	}
+	return table.Name + "_" + suffix, nil
+}
+
+// all the shard tables
+func (table *Table) shardTables() []string {
+	names := make([]string, 0)
+	for _, suffix := range table.shard.Suffixes() {
+		names = append(names, table.Name+"_"+suffix)
+	}
+	return names
+}
+
+// the table should be routed when it's sharded and the table name is not
+// given by Table or a raw sql
+func (session *Session) shardedTable(t reflect.Type) *Table {
+	statement := &session.Statement
+	if statement.AltTableName != "" || statement.RawSQL != "" {
+		return nil
+	}
+	table := statement.RefTable
+	if table == nil {
+		for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map) {
+			t = t.Elem()
+		}
+		if t == nil || t.Kind() != reflect.Struct {
+			return nil
+		}
+		table = session.Engine.autoMapType(t)
+	}
+	if table.shard == nil {
+		return nil
+	}
+	return table
+}
+
+// route the statement to the shard table of the key, the key is the one
+// given by ShardKey, the Id if the shard column is the primary key, or the
+// non-zero shard column of the beans. If there is no key, all the shard
+// tables are returned to fan out, nil is returned when the table is not
+// sharded.
+func (session *Session) routeShard(t reflect.Type, beans ...interface{}) ([]string, error) {
+	table := session.shardedTable(t)
+	if table == nil {
+		return nil, nil
+	}
+
+	statement := &session.Statement
+	key := statement.shardKey
+	column := table.shard.Column()
+	if key == nil && statement.IdParam != nil && len(*statement.IdParam) == 1 &&
+		len(table.PrimaryKeys) == 1 && table.PrimaryKeys[0] == column {
+		key = (*statement.IdParam)[0]
+	}
+	col, ok := table.Columns[column]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("%v has no shard column %v", table.Name, column))
+	}
+	for _, bean := range beans {
+		if key != nil {
+			break
+		}
+		if bean == nil || rType(bean) != table.Type {
+			continue
+		}
+		v := col.ValueOf(bean)
+		if v.IsValid() && !isZero(v) {
+			key = v.Interface()
+		}
+	}
+
+	if key == nil {
+		return table.shardTables(), nil
+	}
+	name, err := table.shardTable(key)
+	if err != nil {
+		return nil, err
+	}
+	statement.AltTableName = name
+	return nil, nil
+}
+
+// the shard table of the inserted bean, "" is returned when the table is not
+// sharded
+func (session *Session) insertShard(table *Table, bean interface{}) (string, error) {
+	if table.shard == nil || session.Statement.AltTableName != "" {
+		return "", nil
+	}
+	col, ok := table.Columns[table.shard.Column()]
+	if !ok {
+		return "", errors.New(fmt.Sprintf("%v has no shard column %v", table.Name, table.shard.Column()))
+	}
+	return table.shardTable(col.ValueOf(bean).Interface())
+}
+
+// insert the beans of every shard table by one statement
+func (session *Session) insertMultiShards(table *Table, sliceValue reflect.Value) (int64, error) {
+	names := make([]string, 0)
+	groups := make(map[string]reflect.Value)
+	for i := 0; i < sliceValue.Len(); i++ {
+		name, err := session.insertShard(table, sliceValue.Index(i).Interface())
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+			groups[name] = reflect.MakeSlice(sliceValue.Type(), 0, 0)
+		}
+		groups[name] = reflect.Append(groups[name], sliceValue.Index(i))
+	}
+
+	defer func() {
+		session.Statement.AltTableName = ""
+	}()
+	var affected int64
+	for _, name := range names {
+		session.Statement.AltTableName = name
+		cnt, err := session.insertMulti(groups[name])
+		affected += cnt
+		if err != nil {
+			return affected, err
+		}
+	}
+	return affected, nil
+}
+
+// reset the session when an operation fails before it starts
+func (session *Session) resetOp() {
+	session.Statement.Init()
+	if session.IsAutoClose {
+		session.Close()
+	}
+}
+
+// run fun on every shard table by a copy of the statement, the session is
+// reset and closed like one operation after all the shards
+func (session *Session) eachShard(names []string, fun func() error) error {
+	statement := session.Statement
+	autoClose := session.IsAutoClose
+	session.IsAutoClose = false
+	defer func() {
+		session.IsAutoClose = autoClose
+		session.resetOp()
+	}()
+
+	for _, name := range names {
+		session.Statement = statement
+		session.Statement.AltTableName = name
+		err := fun()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find on every shard table and merge the records, the ORDER BY and LIMIT
+// are applied to the merged records
+func (session *Session) findShards(names []string, rowsSlicePtr interface{}, condiBean ...interface{}) error {
+	sliceValue := reflect.Indirect(reflect.ValueOf(rowsSlicePtr))
+	if sliceValue.Kind() != reflect.Slice && sliceValue.Kind() != reflect.Map {
+		session.resetOp()
+		return errors.New("needs a pointer to a slice or a map")
+	}
+
+	start, limit := session.Statement.Start, session.Statement.LimitN
+	orders := session.Statement.OrderStr
+	merged := reflect.MakeSlice(sliceValue.Type(), 0, 0)
+	if sliceValue.Kind() == reflect.Map {
+		merged = sliceValue
+	}
+	err := session.eachShard(names, func() error {
+		// every shard should return the records of the first pages
+		session.Statement.Start = 0
+		if limit > 0 {
+			session.Statement.LimitN = start + limit
+		}
+		if sliceValue.Kind() == reflect.Map {
+			return session.find(rowsSlicePtr, condiBean...)
+		}
+		part := reflect.New(sliceValue.Type())
+		part.Elem().Set(reflect.MakeSlice(sliceValue.Type(), 0, 0))
+		err := session.find(part.Interface(), condiBean...)
+		if err != nil {
+			return err
+		}
+		merged = reflect.AppendSlice(merged, part.Elem())
+		return nil
+	})
+	if err != nil || sliceValue.Kind() == reflect.Map {
+		return err
+	}
+
+	elemType := sliceValue.Type().Elem()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	sortRecords(session.Engine, session.Engine.autoMapType(elemType), orders, merged)
+	if start > merged.Len() {
+		start = merged.Len()
+	}
+	merged = merged.Slice(start, merged.Len())
+	if limit > 0 && limit < merged.Len() {
+		merged = merged.Slice(0, limit)
+	}
+	sliceValue.Set(reflect.AppendSlice(sliceValue, merged))
+	return nil
+}
+
+// get from every shard table, the first record by the ORDER BY is returned
+func (session *Session) getShards(names []string, bean interface{}) (bool, error) {
+	slice := reflect.New(reflect.SliceOf(rType(bean)))
+	slice.Elem().Set(reflect.MakeSlice(slice.Elem().Type(), 0, 0))
+	session.Statement.LimitN = 1
+	err := session.findShards(names, slice.Interface(), bean)
+	if err != nil || slice.Elem().Len() == 0 {
+		return false, err
+	}
+	reflect.Indirect(reflect.ValueOf(bean)).Set(slice.Elem().Index(0))
+	return true, nil
+}
+
+// sort the records by the columns of orderStr like "`id` DESC, name", the
+// expressions which are not columns are ignored
+func sortRecords(engine *Engine, table *Table, orderStr string, records reflect.Value) {
+	type order struct {
+		col  *Column
+		desc bool
+	}
+	orders := make([]order, 0)
+	for _, part := range strings.Split(orderStr, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.Replace(fields[0], engine.QuoteStr(), "", -1)
+		if idx := strings.LastIndex(name, "."); idx >= 0 {
+			name = name[idx+1:]
+		}
+		col, ok := table.Columns[name]
+		if !ok {
+			continue
+		}
+		orders = append(orders, order{col, len(fields) > 1 && strings.ToUpper(fields[1]) == "DESC"})
+	}
+	if len(orders) == 0 {
+		return
+	}
+
+	values := make([]reflect.Value, records.Len())
+	beans := make([]interface{}, records.Len())
+	for i := range values {
+		values[i] = records.Index(i)
+		if values[i].Kind() == reflect.Ptr {
+			beans[i] = values[i].Interface()
+		} else {
+			beans[i] = values[i].Addr().Interface()
+		}
+	}
+	idx := make([]int, len(values))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		for _, o := range orders {
+			c := compareValues(o.col.ValueOf(beans[idx[i]]), o.col.ValueOf(beans[idx[j]]))
+			if c != 0 {
+				return (c < 0) != o.desc
+			}
+		}
+		return false
+	})
+	sorted := reflect.MakeSlice(records.Type(), 0, len(values))
+	for _, i := range idx {
+		sorted = reflect.Append(sorted, values[i])
+	}
+	reflect.Copy(records, sorted)
+}
+
+// compare two values of the same type, the unsupported types are equal
+func compareValues(a, b reflect.Value) int {
+	a, b = reflect.Indirect(a), reflect.Indirect(b)
+	if !a.IsValid() || !b.IsValid() {
+		switch {
+		case a.IsValid():
+			return 1
+		case b.IsValid():
+			return -1
+		}
+		return 0
+	}
+	var less, greater bool
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		less, greater = a.Int() < b.Int(), a.Int() > b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		less, greater = a.Uint() < b.Uint(), a.Uint() > b.Uint()
+	case reflect.Float32, reflect.Float64:
+		less, greater = a.Float() < b.Float(), a.Float() > b.Float()
+	case reflect.String:
+		less, greater = a.String() < b.String(), a.String() > b.String()
+	case reflect.Bool:
+		less, greater = !a.Bool() && b.Bool(), a.Bool() && !b.Bool()
+	case reflect.Struct:
+		if ta, ok := a.Interface().(time.Time); ok {
+			tb := b.Interface().(time.Time)
+			less, greater = ta.Before(tb), ta.After(tb)
+		}
+	}
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -2,11 +2,16 @@ package xorm
 
 import (
 	"database/sql"
+	"errors"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 type sqlite3 struct {
 	base
+	returningOnce sync.Once
+	returning     bool
 }
 
 func (db *sqlite3) Init(drivername, dataSourceName string) error {
@@ -62,6 +67,70 @@ func (db *sqlite3) IndexOnTable() bool {
 	return false
 }
 
+// sqlite3 only supports rename table and add column
+func (db *sqlite3) ModifyColumnSql(tableName string, col *Column) ([]string, error) {
+	return nil, errors.New("sqlite3 doesn't support modifying column " + tableName + "." + col.Name)
+}
+
+func (db *sqlite3) SavepointSql(name string) (string, string, string) {
+	return "SAVEPOINT " + name, "RELEASE " + name, "ROLLBACK TO " + name
+}
+
+// sqlite3's transactions are always serializable, and read only is a
+// setting of the connection which should be reset after the transaction
+func (db *sqlite3) SetTransactionSql(level sql.IsolationLevel, readOnly bool) ([]string, []string, []string, error) {
+	_, err := transactionCharacteristics(level, readOnly)
+	if err != nil || !readOnly {
+		return nil, nil, nil, err
+	}
+	return []string{"PRAGMA query_only = 1"}, nil, []string{"PRAGMA query_only = 0"}, nil
+}
+
+// the upsert clause is supported since sqlite 3.24
+func (db *sqlite3) UpsertSql(tableName string, conflictCols, updateCols []string, version string) string {
+	return onConflictSql(db.QuoteStr(), tableName, conflictCols, updateCols, version, "excluded")
+}
+
+// the variables of a statement are at most 999 by default
+func (db *sqlite3) MaxInsertArgs() int {
+	return 999
+}
+
+// RETURNING is supported since sqlite 3.35, the version is checked once
+func (db *sqlite3) SupportReturning() bool {
+	db.returningOnce.Do(func() {
+		cnn, err := sql.Open(db.drivername, db.dataSourceName)
+		if err != nil {
+			return
+		}
+		defer cnn.Close()
+		var version string
+		err = cnn.QueryRow("SELECT sqlite_version()").Scan(&version)
+		if err != nil {
+			return
+		}
+		nums := strings.Split(version, ".")
+		if len(nums) < 2 {
+			return
+		}
+		major, _ := strconv.Atoi(nums[0])
+		minor, _ := strconv.Atoi(nums[1])
+		db.returning = major > 3 || (major == 3 && minor >= 35)
+	})
+	return db.returning
+}
+
+// LastInsertId is the last id of the inserted records
+func (db *sqlite3) ConsecutiveInsertIds() bool {
+	return false
+}
+
+// sqlite3 reports busy or locked database when the lock can't be acquired
+func (db *sqlite3) IsRetryableError(err error) bool {
+	s := err.Error()
+	return strings.Contains(s, "database is locked") || strings.Contains(s, "database table is locked")
+}
+
 func (db *sqlite3) IndexCheckSql(tableName, idxName string) (string, []interface{}) {
 	args := []interface{}{idxName}
 	return "SELECT name FROM sqlite_master WHERE type='index' and name = ?", args
@@ -101,12 +170,20 @@ func (db *sqlite3) GetColumns(tableName string) ([]string, map[string]*Column, e
 	}
 
 	nStart := strings.Index(sql, "(")
-	nEnd := strings.Index(sql, ")")
-	colCreates := strings.Split(sql[nStart+1:nEnd], ",")
+	nEnd := strings.LastIndex(sql, ")")
+	colStr := sql[nStart+1 : nEnd]
+	// the composite primary keys are declared at the end
+	if idx := strings.Index(colStr, "PRIMARY KEY ("); idx >= 0 {
+		colStr = colStr[:idx]
+	}
+	colCreates := strings.Split(colStr, ",")
 	cols := make(map[string]*Column)
 	colSeq := make([]string, 0)
 	for _, colStr := range colCreates {
 		fields := strings.Fields(strings.TrimSpace(colStr))
+		if len(fields) == 0 {
+			continue
+		}
 		col := new(Column)
 		col.Indexes = make(map[string]bool)
 		col.Nullable = true
@@ -122,6 +199,10 @@ func (db *sqlite3) GetColumns(tableName string) ([]string, map[string]*Column, e
 				col.IsPrimaryKey = true
 			case "AUTOINCREMENT":
 				col.IsAutoIncrement = true
+			case "DEFAULT":
+				if idx+1 < len(fields) {
+					col.Default = fields[idx+1]
+				}
 			case "NULL":
 				if fields[idx-1] == "NOT" {
 					col.Nullable = false
@@ -189,6 +270,10 @@ func (db *sqlite3) GetIndexes(tableName string) (map[string]*Index, error) {
 				sql = string(content)
 			}
 		}
+		// the auto created indexes have no sql
+		if sql == "" {
+			continue
+		}
 
 		nNStart := strings.Index(sql, "INDEX")
 		nNEnd := strings.Index(sql, "ON")
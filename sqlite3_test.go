@@ -43,3 +43,25 @@ func BenchmarkSqlite3Cache(t *testing.B) {
 	engine.SetDefaultCacher(NewLRUCacher(NewMemoryStore(), 1000))
 	doBenchCacheFind(engine, t)
 }
+
+func BenchmarkSqlite3Find(t *testing.B) {
+	os.Remove("./test.db")
+	engine, err := NewEngine("sqlite3", "./test.db")
+	defer engine.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	doBenchFind(engine, t)
+}
+
+func BenchmarkSqlite3FindByMap(t *testing.B) {
+	os.Remove("./test.db")
+	engine, err := NewEngine("sqlite3", "./test.db")
+	defer engine.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	doBenchFindByMap(engine, t)
+}
@@ -1,6 +1,7 @@
 package xorm
 
 import (
+	"database/sql/driver"
 	"fmt"
 	"reflect"
 	//"strconv"
@@ -25,6 +26,7 @@ type Statement struct {
 	columnMap     map[string]bool
 	OmitStr       string
 	ConditionStr  string
+	IdParam       *PK
 	AltTableName  string
 	RawSQL        string
 	RawParams     []interface{}
@@ -38,6 +40,13 @@ type Statement struct {
 	IsDistinct    bool
 	allUseBool    bool
 	boolColumnMap map[string]bool
+	subQuery      string
+	subQueryArgs  []interface{}
+	hasSubQuery   bool
+	preloads      []string
+	shardKey      interface{}
+	unscoped      bool
+	chunksInTx    bool
 }
 
 // init
@@ -56,6 +65,7 @@ func (statement *Statement) Init() {
 	statement.OmitStr = ""
 	statement.columnMap = make(map[string]bool)
 	statement.ConditionStr = ""
+	statement.IdParam = nil
 	statement.AltTableName = ""
 	statement.RawSQL = ""
 	statement.RawParams = make([]interface{}, 0)
@@ -65,6 +75,13 @@ func (statement *Statement) Init() {
 	statement.IsDistinct = false
 	statement.allUseBool = false
 	statement.boolColumnMap = make(map[string]bool)
+	statement.subQuery = ""
+	statement.subQueryArgs = make([]interface{}, 0)
+	statement.hasSubQuery = false
+	statement.preloads = make([]string, 0)
+	statement.shardKey = nil
+	statement.unscoped = false
+	statement.chunksInTx = false
 }
 
 // add the raw sql statement
@@ -75,14 +92,21 @@ func (statement *Statement) Sql(querystring string, args ...interface{}) *Statem
 }
 
 // add Where statment
-func (statement *Statement) Where(querystring string, args ...interface{}) *Statement {
+func (statement *Statement) Where(query interface{}, args ...interface{}) *Statement {
+	querystring, args := condToSql(statement.Engine.Quote, query, args)
+	querystring, args = statement.expandSubQuery(querystring, args)
 	statement.WhereStr = querystring
 	statement.Params = args
 	return statement
 }
 
 // add Where & and statment
-func (statement *Statement) And(querystring string, args ...interface{}) *Statement {
+func (statement *Statement) And(query interface{}, args ...interface{}) *Statement {
+	querystring, args := condToSql(statement.Engine.Quote, query, args)
+	querystring, args = statement.expandSubQuery(querystring, args)
+	if querystring == "" {
+		return statement
+	}
 	if statement.WhereStr != "" {
 		statement.WhereStr = fmt.Sprintf("(%v) AND (%v)", statement.WhereStr, querystring)
 	} else {
@@ -93,7 +117,12 @@ func (statement *Statement) And(querystring string, args ...interface{}) *Statem
 }
 
 // add Where & Or statment
-func (statement *Statement) Or(querystring string, args ...interface{}) *Statement {
+func (statement *Statement) Or(query interface{}, args ...interface{}) *Statement {
+	querystring, args := condToSql(statement.Engine.Quote, query, args)
+	querystring, args = statement.expandSubQuery(querystring, args)
+	if querystring == "" {
+		return statement
+	}
 	if statement.WhereStr != "" {
 		statement.WhereStr = fmt.Sprintf("(%v) OR (%v)", statement.WhereStr, querystring)
 	} else {
@@ -103,8 +132,21 @@ func (statement *Statement) Or(querystring string, args ...interface{}) *Stateme
 	return statement
 }
 
-// tempororily set table name
-func (statement *Statement) Table(tableNameOrBean interface{}) *Statement {
+// tempororily set table name, tableNameOrBean could also be a *Session or
+// *Statement which will be used as a derived table named alias, the default
+// alias is "sub"
+func (statement *Statement) Table(tableNameOrBean interface{}, alias ...string) *Statement {
+	if sql, args, ok := subQueryToSql(tableNameOrBean); ok {
+		statement.subQuery = sql
+		statement.subQueryArgs = args
+		statement.hasSubQuery = true
+		statement.AltTableName = "sub"
+		if len(alias) > 0 {
+			statement.AltTableName = alias[0]
+		}
+		return statement
+	}
+
 	t := rType(tableNameOrBean)
 	if t.Kind() == reflect.String {
 		statement.AltTableName = tableNameOrBean.(string)
@@ -125,9 +167,58 @@ func buildConditions(engine *Engine, table *Table, bean interface{}, includeVers
 		fieldValue := col.ValueOf(bean)
 		fieldType := reflect.TypeOf(fieldValue.Interface())
 		var val interface{}
+		// a zero value of a registered type is not a condition, but a pointer
+		// to it is
+		condValue, isPtr := fieldValue, fieldType.Kind() == reflect.Ptr
+		if isPtr && !fieldValue.IsNil() {
+			condValue = fieldValue.Elem()
+		}
+		if c, ok := engine.converterOf(condValue.Type()); ok {
+			if !isPtr && reflect.DeepEqual(fieldValue.Interface(), reflect.Zero(fieldType).Interface()) {
+				continue
+			}
+			v, err := c.value(col, condValue)
+			if err != nil {
+				engine.LogError(err)
+				continue
+			}
+			if v == nil {
+				continue
+			}
+			args = append(args, v)
+			colNames = append(colNames, fmt.Sprintf("%v = ?", engine.Quote(col.Name)))
+			continue
+		}
+		// a nil pointer or a null Valuer is not a condition, but a pointer to
+		// a zero value is
+		if valuer, ok := fieldValue.Interface().(driver.Valuer); ok {
+			if fieldValue.Kind() == reflect.Ptr && fieldValue.IsNil() {
+				continue
+			}
+			v, err := valuer.Value()
+			if err != nil {
+				engine.LogError(err)
+				continue
+			}
+			if v == nil {
+				continue
+			}
+			args = append(args, v)
+			colNames = append(colNames, fmt.Sprintf("%v = ?", engine.Quote(col.Name)))
+			continue
+		}
+		// a pointer is converted as what it points to, and a pointer to a zero
+		// value is a condition too
+		if isPtr {
+			if fieldValue.IsNil() {
+				continue
+			}
+			fieldValue = fieldValue.Elem()
+			fieldType = fieldValue.Type()
+		}
 		switch fieldType.Kind() {
 		case reflect.Bool:
-			if allUseBool {
+			if isPtr || allUseBool {
 				val = fieldValue.Interface()
 			} else if _, ok := boolColumnMap[col.Name]; ok {
 				val = fieldValue.Interface()
@@ -137,7 +228,7 @@ func buildConditions(engine *Engine, table *Table, bean interface{}, includeVers
 				continue
 			}
 		case reflect.String:
-			if fieldValue.String() == "" {
+			if !isPtr && fieldValue.String() == "" {
 				continue
 			}
 			// for MyString, should convert to string or panic
@@ -147,41 +238,35 @@ func buildConditions(engine *Engine, table *Table, bean interface{}, includeVers
 				val = fieldValue.Interface()
 			}
 		case reflect.Int8, reflect.Int16, reflect.Int, reflect.Int32, reflect.Int64:
-			if fieldValue.Int() == 0 {
+			if !isPtr && fieldValue.Int() == 0 {
 				continue
 			}
 			val = fieldValue.Interface()
 		case reflect.Float32, reflect.Float64:
-			if fieldValue.Float() == 0.0 {
+			if !isPtr && fieldValue.Float() == 0.0 {
 				continue
 			}
 			val = fieldValue.Interface()
 		case reflect.Uint8, reflect.Uint16, reflect.Uint, reflect.Uint32, reflect.Uint64:
-			if fieldValue.Uint() == 0 {
+			if !isPtr && fieldValue.Uint() == 0 {
 				continue
 			}
 			val = fieldValue.Interface()
 		case reflect.Struct:
 			if fieldType == reflect.TypeOf(time.Now()) {
 				t := fieldValue.Interface().(time.Time)
-				if t.IsZero() {
+				if !isPtr && t.IsZero() {
 					continue
 				}
-				var str string
-				if col.SQLType.Name == Time {
-					s := t.UTC().Format("2006-01-02 15:04:05")
-					val = s[11:19]
-				} else if col.SQLType.Name == Date {
-					str = t.Format("2006-01-02")
-					val = str
-				} else {
-					val = t
-				}
+				val = engine.formatTime(col, t)
 			} else {
 				engine.autoMapType(fieldValue.Type())
 				if table, ok := engine.Tables[fieldValue.Type()]; ok {
-					pkField := reflect.Indirect(fieldValue).FieldByName(table.PKColumn().FieldName)
-					if pkField.Int() != 0 {
+					if len(table.PrimaryKeys) != 1 {
+						continue
+					}
+					pkField := reflect.Indirect(fieldValue).FieldByName(table.PKColumns()[0].FieldName)
+					if !isZero(pkField) {
 						val = pkField.Interface()
 					} else {
 						continue
@@ -237,6 +322,16 @@ func buildConditions(engine *Engine, table *Table, bean interface{}, includeVers
 	return colNames, args
 }
 
+// the condition to filter the soft deleted records, "" if the table has no
+// deleted column or the statement is unscoped
+func (statement *Statement) deletedCond() string {
+	if statement.RefTable == nil || statement.RefTable.Deleted == "" || statement.unscoped {
+		return ""
+	}
+	quote := statement.Engine.Quote
+	return fmt.Sprintf("%v.%v IS NULL", quote(statement.TableName()), quote(statement.RefTable.Deleted))
+}
+
 // return current tableName
 func (statement *Statement) TableName() string {
 	if statement.AltTableName != "" {
@@ -249,21 +344,63 @@ func (statement *Statement) TableName() string {
 	return ""
 }
 
-// Generate "Where id = ? " statment
-func (statement *Statement) Id(id int64) *Statement {
-	if statement.WhereStr == "" {
-		statement.WhereStr = "(id)=?"
-		statement.Params = []interface{}{id}
-	} else {
-		statement.WhereStr = statement.WhereStr + " AND (id)=?"
-		statement.Params = append(statement.Params, id)
-	}
+// Generate "Where id = ? " statment, id could be a PK for composite primary
+// keys, the conditions are generated when the table is known
+func (statement *Statement) Id(id interface{}) *Statement {
+	statement.IdParam = toPK(id)
 	return statement
 }
 
-// Generate "Where column IN (?) " statment
+// convert IdParam to conditions on the primary key columns
+func (statement *Statement) processIdParam() error {
+	if statement.IdParam == nil {
+		return nil
+	}
+	pk := *statement.IdParam
+	pkCols := statement.RefTable.PKColumns()
+	if len(pk) != len(pkCols) {
+		return ErrPrimaryKeyCount
+	}
+	for i, col := range pkCols {
+		condition := fmt.Sprintf("%v=?", statement.Engine.Quote(col.Name))
+		if statement.WhereStr == "" {
+			statement.WhereStr = condition
+			statement.Params = []interface{}{pk[i]}
+		} else {
+			statement.WhereStr = statement.WhereStr + " AND " + condition
+			statement.Params = append(statement.Params, pk[i])
+		}
+	}
+	statement.IdParam = nil
+	return nil
+}
+
+// generate "(a = ? AND b = ?) OR (a = ? AND b = ?)" for the primary key values
+func (statement *Statement) pksCondition(pks []PK) (string, []interface{}) {
+	quote := statement.Engine.Quote
+	pkNames := statement.RefTable.PrimaryKeys
+	conds := make([]string, 0, len(pks))
+	args := make([]interface{}, 0, len(pks)*len(pkNames))
+	for _, pk := range pks {
+		eqs := make([]string, 0, len(pkNames))
+		for i, name := range pkNames {
+			eqs = append(eqs, quote(name)+" = ?")
+			args = append(args, pk[i])
+		}
+		conds = append(conds, "("+strings.Join(eqs, " AND ")+")")
+	}
+	return strings.Join(conds, " OR "), args
+}
+
+// Generate "Where column IN (?) " statment, if the only arg is a *Session or
+// *Statement, "Where column IN (sub query)" is generated
 func (statement *Statement) In(column string, args ...interface{}) *Statement {
-	inStr := fmt.Sprintf("%v IN (%v)", column, strings.Join(makeArray("?", len(args)), ","))
+	var inStr string
+	if len(args) == 1 && isSubQuery(args[0]) {
+		inStr, args = statement.expandSubQuery(fmt.Sprintf("%v IN ?", column), args)
+	} else {
+		inStr = fmt.Sprintf("%v IN (%v)", column, strings.Join(makeArray("?", len(args)), ","))
+	}
 	if statement.WhereStr == "" {
 		statement.WhereStr = inStr
 		statement.Params = args
@@ -350,7 +487,7 @@ func (statement *Statement) OrderBy(order string) *Statement {
 //The join_operator should be one of INNER, LEFT OUTER, CROSS etc - this will be prepended to JOIN
 func (statement *Statement) Join(join_operator, tablename, condition string) *Statement {
 	if statement.JoinStr != "" {
-		statement.JoinStr = statement.JoinStr + fmt.Sprintf("%v JOIN %v ON %v", join_operator, tablename, condition)
+		statement.JoinStr = statement.JoinStr + fmt.Sprintf(" %v JOIN %v ON %v", join_operator, tablename, condition)
 	} else {
 		statement.JoinStr = fmt.Sprintf("%v JOIN %v ON %v", join_operator, tablename, condition)
 	}
@@ -369,7 +506,43 @@ func (statement *Statement) Having(conditions string) *Statement {
 	return statement
 }
 
+// if the struct has extends structs and there are joins, every extends
+// struct's columns are selected from its own table
+func (statement *Statement) isExtendsJoin() bool {
+	return statement.JoinStr != "" && statement.RefTable != nil && len(statement.RefTable.extends) > 0
+}
+
+// generate "table.column AS `table.column`" for the columns of the extends
+// structs and "table.column" for the struct's own columns, so the columns which
+// have the same name in different tables could be scanned to the right fields
+func (statement *Statement) genExtendsColumnStr() string {
+	quote := statement.Engine.Quote
+	table := statement.RefTable
+	colNames := make([]string, 0)
+	for _, name := range table.ColumnsSeq {
+		col := table.Columns[name]
+		if strings.Contains(col.FieldName, ".") || col.MapType == ONLYTODB {
+			continue
+		}
+		colNames = append(colNames, quote(statement.TableName())+"."+quote(col.Name))
+	}
+	for _, ext := range table.extends {
+		for _, name := range ext.table.ColumnsSeq {
+			col := ext.table.Columns[name]
+			if col.MapType == ONLYTODB {
+				continue
+			}
+			colNames = append(colNames, fmt.Sprintf("%v.%v AS %v", quote(ext.tableName),
+				quote(col.Name), quote(ext.tableName+"."+col.Name)))
+		}
+	}
+	return strings.Join(colNames, ", ")
+}
+
 func (statement *Statement) genColumnStr() string {
+	if statement.isExtendsJoin() {
+		return statement.genExtendsColumnStr()
+	}
 	table := statement.RefTable
 	colNames := make([]string, 0)
 	for _, col := range table.Columns {
@@ -387,13 +560,22 @@ func (statement *Statement) genColumnStr() string {
 }
 
 func (statement *Statement) genCreateSQL() string {
-	sql := "CREATE TABLE IF NOT EXISTS " + statement.Engine.Quote(statement.TableName()) + " ("
+	quote := statement.Engine.Quote
+	pkList := statement.RefTable.PrimaryKeys
+	sql := "CREATE TABLE IF NOT EXISTS " + quote(statement.TableName()) + " ("
 	for _, colName := range statement.RefTable.ColumnsSeq {
 		col := statement.RefTable.Columns[colName]
-		sql += col.String(statement.Engine.dialect)
+		if col.IsPrimaryKey && len(pkList) == 1 {
+			sql += col.String(statement.Engine.dialect)
+		} else {
+			sql += col.StringNoPk(statement.Engine.dialect)
+		}
 		sql = strings.TrimSpace(sql)
 		sql += ", "
 	}
+	if len(pkList) > 1 {
+		sql += "PRIMARY KEY (" + quote(strings.Join(pkList, quote(", "))) + "), "
+	}
 	sql = sql[:len(sql)-2] + ")"
 	if statement.Engine.dialect.SupportEngine() && statement.StoreEngine != "" {
 		sql += " ENGINE=" + statement.StoreEngine
@@ -464,9 +646,12 @@ func (s *Statement) genDropSQL() string {
 	return sql
 }
 
-func (statement Statement) genGetSql(bean interface{}) (string, []interface{}) {
+func (statement Statement) genGetSql(bean interface{}) (string, []interface{}, error) {
 	table := statement.Engine.autoMap(bean)
 	statement.RefTable = table
+	if err := statement.processIdParam(); err != nil {
+		return "", nil, err
+	}
 
 	colNames, args := buildConditions(statement.Engine, table, bean, true,
 		statement.allUseBool, statement.boolColumnMap)
@@ -478,7 +663,7 @@ func (statement Statement) genGetSql(bean interface{}) (string, []interface{}) {
 		columnStr = statement.genColumnStr()
 	}
 
-	return statement.genSelectSql(columnStr), append(statement.Params, statement.BeanArgs...)
+	return statement.genSelectSql(columnStr), statement.selectArgs(), nil
 }
 
 func (s *Statement) genAddColumnStr(col *Column) (string, []interface{}) {
@@ -502,18 +687,140 @@ func (s *Statement) genAddUniqueStr(uqeName string, cols []string) (string, []in
 	return sql, []interface{}{}
 }
 
-func (statement Statement) genCountSql(bean interface{}) (string, []interface{}) {
+func (s *Statement) genDropIndexStr(idxName string) (string, []interface{}) {
+	sql := fmt.Sprintf("DROP INDEX %v", s.Engine.Quote(idxName))
+	if s.Engine.dialect.IndexOnTable() {
+		sql += fmt.Sprintf(" ON %v", s.Engine.Quote(s.TableName()))
+	}
+	return sql + ";", []interface{}{}
+}
+
+func (statement Statement) genCountSql(bean interface{}) (string, []interface{}, error) {
 	table := statement.Engine.autoMap(bean)
 	statement.RefTable = table
+	if err := statement.processIdParam(); err != nil {
+		return "", nil, err
+	}
 
 	colNames, args := buildConditions(statement.Engine, table, bean, true, statement.allUseBool, statement.boolColumnMap)
 	statement.ConditionStr = strings.Join(colNames, " AND ")
 	statement.BeanArgs = args
 	var id string = "*"
-	if table.PrimaryKey != "" {
-		id = statement.Engine.Quote(table.PrimaryKey)
+	if len(table.PrimaryKeys) == 1 {
+		id = statement.Engine.Quote(table.PrimaryKeys[0])
 	}
-	return statement.genSelectSql(fmt.Sprintf("COUNT(%v) AS %v", id, statement.Engine.Quote("total"))), append(statement.Params, statement.BeanArgs...)
+	return statement.genSelectSql(fmt.Sprintf("COUNT(%v) AS %v", id, statement.Engine.Quote("total"))), statement.selectArgs(), nil
+}
+
+// the args of the select sql, the args of the derived table are before the
+// others
+func (statement *Statement) selectArgs() []interface{} {
+	args := make([]interface{}, 0, len(statement.subQueryArgs)+len(statement.Params)+len(statement.BeanArgs))
+	args = append(args, statement.subQueryArgs...)
+	args = append(args, statement.Params...)
+	return append(args, statement.BeanArgs...)
+}
+
+// generate the sql and args when the statement is used as a sub query, Id is
+// not supported in a sub query, please use Where instead
+func (statement Statement) genSubQuery() (string, []interface{}) {
+	if statement.RawSQL != "" {
+		return statement.RawSQL, statement.RawParams
+	}
+	columnStr := statement.ColumnStr
+	if columnStr == "" {
+		if statement.RefTable != nil {
+			columnStr = statement.genColumnStr()
+		} else {
+			columnStr = "*"
+		}
+	}
+	return statement.genSelectSql(columnStr), statement.selectArgs()
+}
+
+func isSubQuery(arg interface{}) bool {
+	switch arg.(type) {
+	case *Session, *Statement:
+		return true
+	}
+	return false
+}
+
+// get the sql and args if arg is a *Session or *Statement
+func subQueryToSql(arg interface{}) (string, []interface{}, bool) {
+	switch sub := arg.(type) {
+	case *Session:
+		sql, args := sub.Statement.genSubQuery()
+		return sql, args, true
+	case *Statement:
+		sql, args := sub.genSubQuery()
+		return sql, args, true
+	}
+	return "", nil, false
+}
+
+// the positions of the "?" placeholders of a sql, the "?"s in the quoted
+// strings or identifiers and the postgres operators "?|" and "?&" are skipped
+func placeholders(sql string) []int {
+	positions := make([]int, 0)
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			if i+1 < len(sql) && (sql[i+1] == '|' || sql[i+1] == '&') {
+				i++
+				continue
+			}
+			positions = append(positions, i)
+		}
+	}
+	return positions
+}
+
+// replace every "?" whose arg is a sub query by "(sub query sql)" and the
+// arg by the sub query's args, so the args are still in order of the "?"s
+func (statement *Statement) expandSubQuery(sql string, args []interface{}) (string, []interface{}) {
+	found := false
+	for _, arg := range args {
+		if isSubQuery(arg) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return sql, args
+	}
+	statement.hasSubQuery = true
+
+	newArgs := make([]interface{}, 0, len(args))
+	positions := placeholders(sql)
+	newSql := ""
+	last := 0
+	for i, pos := range positions {
+		if i >= len(args) {
+			break
+		}
+		if subSql, subArgs, ok := subQueryToSql(args[i]); ok {
+			newSql += sql[last:pos] + "(" + subSql + ")"
+			newArgs = append(newArgs, subArgs...)
+		} else {
+			newSql += sql[last : pos+1]
+			newArgs = append(newArgs, args[i])
+		}
+		last = pos + 1
+	}
+	newSql += sql[last:]
+	if len(positions) < len(args) {
+		newArgs = append(newArgs, args[len(positions):]...)
+	}
+	return newSql, newArgs
 }
 
 func (statement Statement) genSelectSql(columnStr string) (a string) {
@@ -525,8 +832,19 @@ func (statement Statement) genSelectSql(columnStr string) (a string) {
 	if statement.IsDistinct {
 		distinct = "DISTINCT "
 	}
-	a = fmt.Sprintf("SELECT %v%v FROM %v", distinct, columnStr,
-		statement.Engine.Quote(statement.TableName()))
+	from := statement.Engine.Quote(statement.TableName())
+	if statement.subQuery != "" {
+		from = fmt.Sprintf("(%v) AS %v", statement.subQuery, from)
+	} else if deleted := statement.deletedCond(); deleted != "" {
+		if statement.WhereStr != "" {
+			statement.WhereStr = fmt.Sprintf("(%v) AND %v", statement.WhereStr, deleted)
+		} else if statement.ConditionStr != "" {
+			statement.ConditionStr = fmt.Sprintf("(%v) AND %v", statement.ConditionStr, deleted)
+		} else {
+			statement.ConditionStr = deleted
+		}
+	}
+	a = fmt.Sprintf("SELECT %v%v FROM %v", distinct, columnStr, from)
 	if statement.JoinStr != "" {
 		a = fmt.Sprintf("%v %v", a, statement.JoinStr)
 	}
@@ -0,0 +1,226 @@
+package xorm
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+)
+
+// StmtCacheStats are the counters of the prepared statements cache
+type StmtCacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// a cached prepared statement, it's closed after it's removed from the cache
+// and no operation is using it
+type cachedStmt struct {
+	sql     string
+	stmt    *sql.Stmt
+	refs    int
+	removed bool
+}
+
+// stmtCache is a LRU cache of the prepared statements of a sql.DB or a
+// sql.Tx, the statements are keyed by the final sql after the filters
+type stmtCache struct {
+	mutex sync.Mutex
+	max   int
+	list  *list.List
+	index map[string]*list.Element
+}
+
+func newStmtCache(max int) *stmtCache {
+	return &stmtCache{max: max, list: list.New(), index: make(map[string]*list.Element)}
+}
+
+// get a statement and mark it in use, nil is returned if it's not cached
+func (c *stmtCache) get(sql string) *cachedStmt {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	el, ok := c.index[sql]
+	if !ok {
+		return nil
+	}
+	c.list.MoveToFront(el)
+	cs := el.Value.(*cachedStmt)
+	cs.refs++
+	return cs
+}
+
+// put a prepared statement in use into the cache, the least recently used
+// statements are removed when the cache is full
+func (c *stmtCache) put(sql string, stmt *sql.Stmt) *cachedStmt {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cs := &cachedStmt{sql: sql, stmt: stmt, refs: 1}
+	if c.index == nil {
+		// the cache is closed
+		cs.removed = true
+		return cs
+	}
+	if el, ok := c.index[sql]; ok {
+		c.remove(el)
+	}
+	c.index[sql] = c.list.PushFront(cs)
+	for c.list.Len() > c.max {
+		c.remove(c.list.Back())
+	}
+	return cs
+}
+
+func (c *stmtCache) remove(el *list.Element) {
+	cs := el.Value.(*cachedStmt)
+	c.list.Remove(el)
+	delete(c.index, cs.sql)
+	cs.removed = true
+	if cs.refs == 0 {
+		cs.stmt.Close()
+	}
+}
+
+// release a statement after the operation, it's closed if it was removed
+// from the cache
+func (c *stmtCache) release(cs *cachedStmt) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cs.refs--
+	if cs.removed && cs.refs == 0 {
+		cs.stmt.Close()
+	}
+}
+
+// remove all the statements, the statements in use are closed when they're
+// released
+func (c *stmtCache) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for c.list.Len() > 0 {
+		c.remove(c.list.Back())
+	}
+	c.index = nil
+}
+
+// SetStmtCache caches at most size prepared statements for every sql.DB and
+// every transaction, so the same sql is not prepared again. The statements
+// of a transaction are closed when it's committed or rollbacked. The cache
+// is disabled by default or when size is not positive.
+func (engine *Engine) SetStmtCache(size int) {
+	engine.stmtMutex.Lock()
+	engine.stmtCacheSize = size
+	engine.stmtMutex.Unlock()
+	engine.closeStmtCaches()
+}
+
+// StmtCacheStats returns the hits and misses of the prepared statements
+// cache of the engine, the reads of an engine group's slaves are counted by
+// the slaves
+func (engine *Engine) StmtCacheStats() StmtCacheStats {
+	return StmtCacheStats{
+		Hits:   atomic.LoadInt64(&engine.stmtStats.Hits),
+		Misses: atomic.LoadInt64(&engine.stmtStats.Misses),
+	}
+}
+
+// the statements cache of a db, nil is returned if the cache is disabled
+func (engine *Engine) dbStmtCache(db *sql.DB) *stmtCache {
+	engine.stmtMutex.Lock()
+	defer engine.stmtMutex.Unlock()
+	if engine.stmtCacheSize <= 0 {
+		return nil
+	}
+	if engine.stmtCaches == nil {
+		engine.stmtCaches = make(map[*sql.DB]*stmtCache)
+	}
+	cache, ok := engine.stmtCaches[db]
+	if !ok {
+		cache = newStmtCache(engine.stmtCacheSize)
+		engine.stmtCaches[db] = cache
+	}
+	return cache
+}
+
+// close the cached statements of all the dbs
+func (engine *Engine) closeStmtCaches() {
+	engine.stmtMutex.Lock()
+	defer engine.stmtMutex.Unlock()
+	for db, cache := range engine.stmtCaches {
+		cache.close()
+		delete(engine.stmtCaches, db)
+	}
+}
+
+// close the cached statements of a db before the db is closed
+func (engine *Engine) closeStmtCache(db *sql.DB) {
+	engine.stmtMutex.Lock()
+	defer engine.stmtMutex.Unlock()
+	if cache, ok := engine.stmtCaches[db]; ok {
+		cache.close()
+		delete(engine.stmtCaches, db)
+	}
+}
+
+// the engine whose statements cache and counters are used by the db or the
+// transaction which prepares the statements
+func (session *Session) stmtEngine(p preparer) *Engine {
+	if session.slaveDb != nil && p == session.slaveDb {
+		return session.slave
+	}
+	return session.Engine
+}
+
+// the statements cache of the db or the transaction which prepares the
+// statements
+func (session *Session) stmtCache(p preparer) *stmtCache {
+	switch p {
+	case session.Tx:
+		if session.txStmts == nil {
+			session.Engine.stmtMutex.Lock()
+			if size := session.Engine.stmtCacheSize; size > 0 {
+				session.txStmts = newStmtCache(size)
+			}
+			session.Engine.stmtMutex.Unlock()
+		}
+		return session.txStmts
+	case session.slaveDb:
+		return session.slave.dbStmtCache(session.slaveDb)
+	}
+	return session.Engine.dbStmtCache(session.Db)
+}
+
+// close the cached statements of the transaction when it ends
+func (session *Session) closeTxStmts() {
+	if session.txStmts != nil {
+		session.txStmts.close()
+		session.txStmts = nil
+	}
+}
+
+// prepare the sql by the db or the transaction, the statement is taken from
+// the cache if it's enabled, the returned function should be called after
+// the statement is used
+func (session *Session) prepare(ctx context.Context, p preparer, sql string) (*sql.Stmt, func(), error) {
+	cache := session.stmtCache(p)
+	if cache == nil {
+		s, err := p.PrepareContext(ctx, sql)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, func() { s.Close() }, nil
+	}
+
+	stats := session.stmtEngine(p).stmtStats
+	if cs := cache.get(sql); cs != nil {
+		atomic.AddInt64(&stats.Hits, 1)
+		return cs.stmt, func() { cache.release(cs) }, nil
+	}
+	atomic.AddInt64(&stats.Misses, 1)
+	s, err := p.PrepareContext(ctx, sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	cs := cache.put(sql, s)
+	return s, func() { cache.release(cs) }, nil
+}
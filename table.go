@@ -1,8 +1,10 @@
 package xorm
 
 import (
+	"database/sql"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -132,9 +134,13 @@ func Type2SQLType(t reflect.Type) (st SQLType) {
 		st = SQLType{Bool, 0, 0}
 	case reflect.String:
 		st = SQLType{Varchar, 255, 0}
+	case reflect.Ptr:
+		st = Type2SQLType(t.Elem())
 	case reflect.Struct:
 		if t == reflect.TypeOf(tm) {
 			st = SQLType{DateTime, 0, 0}
+		} else if nullType, ok := nullSQLTypes[t]; ok {
+			st = nullType
 		} else {
 			st = SQLType{Text, 0, 0}
 		}
@@ -144,6 +150,14 @@ func Type2SQLType(t reflect.Type) (st SQLType) {
 	return
 }
 
+// the sql types of the nullable types of database/sql
+var nullSQLTypes = map[reflect.Type]SQLType{
+	reflect.TypeOf(sql.NullString{}):  {Varchar, 255, 0},
+	reflect.TypeOf(sql.NullInt64{}):   {BigInt, 0, 0},
+	reflect.TypeOf(sql.NullFloat64{}): {Double, 0, 0},
+	reflect.TypeOf(sql.NullBool{}):    {Bool, 0, 0},
+}
+
 // default sql type change to go types
 func SQLType2Type(st SQLType) reflect.Type {
 	name := strings.ToUpper(st.Name)
@@ -218,6 +232,7 @@ type Column struct {
 	IsUpdated       bool
 	IsCascade       bool
 	IsVersion       bool
+	IsDeleted       bool
 }
 
 // generate column description string according dialect
@@ -247,6 +262,26 @@ func (col *Column) String(d dialect) string {
 	return sql
 }
 
+// generate column description string without primary key according dialect,
+// composite primary keys are declared on the table
+func (col *Column) StringNoPk(d dialect) string {
+	sql := d.QuoteStr() + col.Name + d.QuoteStr() + " "
+
+	sql += d.SqlType(col) + " "
+
+	if col.Nullable {
+		sql += "NULL "
+	} else {
+		sql += "NOT NULL "
+	}
+
+	if col.Default != "" {
+		sql += "DEFAULT " + col.Default + " "
+	}
+
+	return sql
+}
+
 // return col's filed of struct's value
 func (col *Column) ValueOf(bean interface{}) reflect.Value {
 	var fieldValue reflect.Value
@@ -266,21 +301,80 @@ func (col *Column) ValueOf(bean interface{}) reflect.Value {
 
 // database table
 type Table struct {
-	Name       string
-	Type       reflect.Type
-	ColumnsSeq []string
-	Columns    map[string]*Column
-	Indexes    map[string]*Index
-	PrimaryKey string
-	Created    string
-	Updated    string
-	Version    string
-	Cacher     Cacher
+	Name          string
+	Type          reflect.Type
+	ColumnsSeq    []string
+	Columns       map[string]*Column
+	Indexes       map[string]*Index
+	PrimaryKeys   []string
+	AutoIncrement string
+	Created       string
+	Updated       string
+	Version       string
+	Deleted       string
+	Cacher        Cacher
+	extends       []*extendsTable
+	relations     map[string]*relation
+	shard         ShardRule
+	scanMutex     sync.Mutex
+	scanPlans     map[string]*scanPlan
 }
 
-// if has primary key, return column
+// a struct embedded by the extends tag, when joining its columns are selected
+// from tableName and aliased as "tableName.column"
+type extendsTable struct {
+	fieldName string
+	tableName string
+	table     *Table
+}
+
+// find the column of an extends struct by its alias "tableName.column"
+func (table *Table) extendsColumn(alias string) (*Column, bool) {
+	idx := strings.Index(alias, ".")
+	if idx < 0 {
+		return nil, false
+	}
+	for _, ext := range table.extends {
+		if ext.tableName == alias[:idx] {
+			col, ok := ext.table.Columns[alias[idx+1:]]
+			return col, ok
+		}
+	}
+	return nil, false
+}
+
+// return all the primary key columns in declared order
+func (table *Table) PKColumns() []*Column {
+	columns := make([]*Column, 0, len(table.PrimaryKeys))
+	for _, name := range table.PrimaryKeys {
+		columns = append(columns, table.Columns[name])
+	}
+	return columns
+}
+
+// PKColumn returns the primary key column, nil is returned when the table
+// has no or composite primary keys.
+//
+// Deprecated: use PKColumns, which supports composite primary keys.
 func (table *Table) PKColumn() *Column {
-	return table.Columns[table.PrimaryKey]
+	if len(table.PrimaryKeys) != 1 {
+		return nil
+	}
+	return table.Columns[table.PrimaryKeys[0]]
+}
+
+// if has autoincrement column, return it
+func (table *Table) AutoIncrColumn() *Column {
+	return table.Columns[table.AutoIncrement]
+}
+
+// return bean's primary key values
+func (table *Table) pkValues(bean interface{}) PK {
+	pk := make(PK, 0, len(table.PrimaryKeys))
+	for _, col := range table.PKColumns() {
+		pk = append(pk, col.ValueOf(bean).Interface())
+	}
+	return pk
 }
 
 // add a column to table
@@ -288,7 +382,10 @@ func (table *Table) AddColumn(col *Column) {
 	table.ColumnsSeq = append(table.ColumnsSeq, col.Name)
 	table.Columns[col.Name] = col
 	if col.IsPrimaryKey {
-		table.PrimaryKey = col.Name
+		table.PrimaryKeys = append(table.PrimaryKeys, col.Name)
+	}
+	if col.IsAutoIncrement {
+		table.AutoIncrement = col.Name
 	}
 	if col.IsCreated {
 		table.Created = col.Name
@@ -299,6 +396,9 @@ func (table *Table) AddColumn(col *Column) {
 	if col.IsVersion {
 		table.Version = col.Name
 	}
+	if col.IsDeleted {
+		table.Deleted = col.Name
+	}
 }
 
 // add an index or an unique to table
@@ -321,7 +421,7 @@ func (table *Table) genCols(session *Session, bean interface{}, useCol bool, inc
 		}
 
 		fieldValue := col.ValueOf(bean)
-		if col.IsAutoIncrement && fieldValue.Int() == 0 {
+		if (col.IsAutoIncrement || col.IsDeleted) && isZero(fieldValue) {
 			continue
 		}
 
@@ -337,7 +437,7 @@ func (table *Table) genCols(session *Session, bean interface{}, useCol bool, inc
 		}
 
 		if (col.IsCreated || col.IsUpdated) && session.Statement.UseAutoTime {
-			args = append(args, time.Now())
+			args = append(args, session.Engine.nowTime(col))
 		} else {
 			arg, err := session.value2Interface(col, fieldValue)
 			if err != nil {
@@ -0,0 +1,127 @@
+package xorm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(tm)
+
+// the layouts of the times read as strings, a time without an offset is in
+// the database's time zone
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// the time zone of the application, the times read from the database are
+// converted to it
+func (engine *Engine) tzLocation() *time.Location {
+	if engine.TZLocation == nil {
+		return time.Local
+	}
+	return engine.TZLocation
+}
+
+// the time zone in which the times are stored in the database
+func (engine *Engine) databaseTZ() *time.Location {
+	if engine.DatabaseTZ == nil {
+		return time.Local
+	}
+	return engine.DatabaseTZ
+}
+
+// the value of a time written to a column, it's formatted in the database's
+// time zone, and a date is the date in the application's time zone. The zero
+// time is not converted so that it's read as the zero time.
+func (engine *Engine) formatTime(col *Column, t time.Time) interface{} {
+	if !t.IsZero() {
+		if col.SQLType.Name == Date {
+			t = t.In(engine.tzLocation())
+		} else {
+			t = t.In(engine.databaseTZ())
+		}
+	}
+	switch col.SQLType.Name {
+	case Date:
+		return t.Format("2006-01-02")
+	case Time:
+		return t.Format("15:04:05")
+	}
+	return t.Format("2006-01-02 15:04:05.999999")
+}
+
+// the current time as the value of an autotime column
+func (engine *Engine) nowTime(col *Column) interface{} {
+	return engine.formatTime(col, time.Now())
+}
+
+// the drivers return the time of a column without a time zone as UTC or in a
+// zone without name
+func zoneless(t time.Time) bool {
+	name, offset := t.Zone()
+	return t.Location() == time.UTC || (name == "" && offset == 0)
+}
+
+// a time scanned by the driver is converted to the application's time zone,
+// a time without a zone is taken as a time in the database's time zone
+func (engine *Engine) dbTime(col *Column, t time.Time) time.Time {
+	if zoneless(t) {
+		if t.IsZero() {
+			return t
+		}
+		loc := engine.databaseTZ()
+		if col.SQLType.Name == Date {
+			loc = engine.tzLocation()
+		}
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+	}
+	return t.In(engine.tzLocation())
+}
+
+// parse a time read as a string or a time stamp, and convert it to the
+// application's time zone
+func (engine *Engine) parseTime(col *Column, sdata string) (time.Time, error) {
+	if sdata == "" || strings.HasPrefix(sdata, "0000-00-00") || strings.HasPrefix(sdata, "0001-01-01 00:00:00") {
+		return time.Time{}, nil
+	}
+	// time stamp
+	if !strings.ContainsAny(sdata, "- :") {
+		sd, err := strconv.ParseInt(sdata, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.New(fmt.Sprintf("unsupported time format %v: %v", sdata, err))
+		}
+		return time.Unix(0, sd).In(engine.tzLocation()), nil
+	}
+
+	if len(sdata) == 10 && sdata[4] == '-' && sdata[7] == '-' {
+		x, err := time.ParseInLocation("2006-01-02", sdata, engine.tzLocation())
+		if err != nil {
+			return time.Time{}, errors.New(fmt.Sprintf("unsupported time format %v: %v", sdata, err))
+		}
+		return x, nil
+	}
+	if len(sdata) < 19 {
+		if col.SQLType.Name != Time {
+			return time.Time{}, errors.New(fmt.Sprintf("unsupported time format %v", sdata))
+		}
+		sdata = "2006-01-02 " + sdata
+	}
+
+	var err error
+	for _, layout := range timeLayouts {
+		var x time.Time
+		x, err = time.ParseInLocation(layout, sdata, engine.databaseTZ())
+		if err == nil {
+			return x.In(engine.tzLocation()), nil
+		}
+	}
+	return time.Time{}, errors.New(fmt.Sprintf("unsupported time format %v: %v", sdata, err))
+}
@@ -0,0 +1,153 @@
+package xorm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Upsert inserts the bean as a record, if the conflict columns, which are the
+// primary keys by default, conflict with an existing record, the record is
+// updated by the bean instead. Cols and Omit choose the columns to insert and
+// update, the conflict columns, the primary keys and the created columns are
+// not updated, and the version is increased when updating. The returned
+// affected rows are what the database reports, mysql reports 2 for an updated
+// record.
+func (session *Session) Upsert(bean interface{}, conflictCols ...string) (int64, error) {
+	err := session.newDb()
+	if err != nil {
+		return 0, err
+	}
+	defer session.Statement.Init()
+	if session.IsAutoClose {
+		defer session.Close()
+	}
+
+	return session.upsert(bean, conflictCols)
+}
+
+func (session *Session) upsert(bean interface{}, conflictCols []string) (int64, error) {
+	table := session.Engine.autoMap(bean)
+	session.Statement.RefTable = table
+	initVersion(table, bean)
+
+	name, err := session.insertShard(table, bean)
+	if err != nil {
+		return 0, err
+	}
+	if name != "" {
+		session.Statement.AltTableName = name
+		defer func() {
+			session.Statement.AltTableName = ""
+		}()
+	}
+
+	if len(conflictCols) == 0 {
+		conflictCols = table.PrimaryKeys
+	}
+	if len(conflictCols) == 0 {
+		return 0, errors.New(fmt.Sprintf("upsert table %v without primary key needs the conflict columns", table.Name))
+	}
+	conflicts := make(map[string]bool)
+	for _, col := range conflictCols {
+		conflicts[strings.ToLower(col)] = true
+	}
+
+	colNames, args, err := table.genCols(session, bean, false, false)
+	if err != nil {
+		return 0, err
+	}
+	updateCols := make([]string, 0, len(colNames))
+	for _, colName := range colNames {
+		col := table.Columns[colName]
+		if conflicts[strings.ToLower(colName)] || col.IsPrimaryKey || col.IsCreated || colName == table.Version {
+			continue
+		}
+		updateCols = append(updateCols, colName)
+	}
+
+	colPlaces := strings.Repeat("?, ", len(colNames))
+	colPlaces = colPlaces[0 : len(colPlaces)-2]
+
+	sql := fmt.Sprintf("INSERT INTO %v (%v%v%v) VALUES (%v)%v",
+		session.Engine.Quote(session.Statement.TableName()),
+		session.Engine.QuoteStr(),
+		strings.Join(colNames, session.Engine.Quote(", ")),
+		session.Engine.QuoteStr(),
+		colPlaces,
+		session.Engine.dialect.UpsertSql(session.Statement.TableName(), conflictCols, updateCols, table.Version))
+
+	res, err := session.exec(sql, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	if table.Cacher != nil && session.Statement.UseCache {
+		session.cacheUpsert(bean, conflicts)
+	}
+
+	return res.RowsAffected()
+}
+
+// the updated record is deleted from the cache by the bean's primary key if
+// the primary key is the conflict columns, or all the cached beans of the
+// table are cleared, since the record may be found by another unique key
+func (session *Session) cacheUpsert(bean interface{}, conflicts map[string]bool) error {
+	table := session.Statement.RefTable
+	if len(table.PrimaryKeys) == 0 {
+		return ErrCacheFailed
+	}
+
+	cacher := table.Cacher
+	tableName := session.Statement.TableName()
+	session.Engine.LogDebug("[xorm:cacheUpsert] clear cached table sql:", tableName)
+	cacher.ClearIds(tableName)
+
+	byPk := len(conflicts) == len(table.PrimaryKeys)
+	for _, col := range table.PKColumns() {
+		if !conflicts[strings.ToLower(col.Name)] || isZero(col.ValueOf(bean)) {
+			byPk = false
+		}
+	}
+	if byPk {
+		pk := table.pkValues(bean)
+		if sid, err := pk.ToString(); err == nil {
+			session.Engine.LogDebug("[xorm:cacheUpsert] delete cache obj", tableName, sid)
+			cacher.DelBean(tableName, sid)
+			return nil
+		}
+	}
+
+	session.Engine.LogDebug("[xorm:cacheUpsert] clear cached table beans:", tableName)
+	cacher.ClearBeans(tableName)
+	return nil
+}
+
+// the assignments of the updated columns by the inserted values, and the
+// version is increased
+func upsertSets(quoteStr, tableName string, updateCols []string, version string, inserted func(string) string) []string {
+	quote := func(name string) string {
+		return quoteStr + name + quoteStr
+	}
+	sets := make([]string, 0, len(updateCols)+1)
+	for _, colName := range updateCols {
+		sets = append(sets, quote(colName)+" = "+inserted(quote(colName)))
+	}
+	if version != "" {
+		sets = append(sets, quote(version)+" = "+quote(tableName)+"."+quote(version)+" + 1")
+	}
+	return sets
+}
+
+// the ON CONFLICT clause of postgres and sqlite3, excluded is the name of the
+// inserted row
+func onConflictSql(quoteStr, tableName string, conflictCols, updateCols []string, version, excluded string) string {
+	target := quoteStr + strings.Join(conflictCols, quoteStr+", "+quoteStr) + quoteStr
+	sets := upsertSets(quoteStr, tableName, updateCols, version, func(col string) string {
+		return excluded + "." + col
+	})
+	if len(sets) == 0 {
+		return " ON CONFLICT (" + target + ") DO NOTHING"
+	}
+	return " ON CONFLICT (" + target + ") DO UPDATE SET " + strings.Join(sets, ", ")
+}
@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"runtime"
 	"sync"
+	"time"
 )
 
 const (
@@ -21,7 +22,8 @@ func close(engine *Engine) {
 // drivers
 func NewEngine(driverName string, dataSourceName string) (*Engine, error) {
 	engine := &Engine{DriverName: driverName, Mapper: SnakeMapper{},
-		DataSourceName: dataSourceName, Filters: make([]Filter, 0)}
+		DataSourceName: dataSourceName, Filters: make([]Filter, 0),
+		TZLocation: time.Local, DatabaseTZ: time.Local, stmtStats: &StmtCacheStats{}}
 
 	if driverName == SQLITE {
 		engine.dialect = &sqlite3{}
@@ -184,11 +184,11 @@ func genGoImports(tables []*xorm.Table) map[string]string {
 
 func typestring(col *xorm.Column) string {
 	st := col.SQLType
-	if col.IsPrimaryKey {
-		return "int64"
-	}
 	t := xorm.SQLType2Type(st)
 	s := t.String()
+	if col.IsPrimaryKey && s == "int" {
+		return "int64"
+	}
 	if s == "[]uint8" {
 		return "[]byte"
 	}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	_ "github.com/bylevel/pq"
+	"github.com/dvirsky/go-pylog/logging"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/lunny/xorm"
+	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/ziutek/mymysql/godrv"
+	"path/filepath"
+	"strconv"
+)
+
+var CmdMigrate = &Command{
+	UsageLine: "migrate [-t tableName] driverName datasourceName migrationsPath [up|down|status] [version]",
+	Short:     "migrate a db by sql files",
+	Long: `
+apply or rollback the sql migrations in migrationsPath, and keep the applied versions in a table.
+
+	-t				Set the table name which keeps the applied versions, the default is xorm_migration
+	driverName		Database driver name, now supported four: mysql mymysql sqlite3 postgres
+	datasourceName	Database connection uri, for detail infomation please visit driver's project page
+	migrationsPath	The dir of the sql files, the file names should be like 20140101_create_user.up.sql
+					and 20140101_create_user.down.sql, the number before "_" is the version
+	up				Apply all pending migrations, or the migrations until the version. It's the default
+	down			Rollback the last applied migration, or rollback to the version
+	status			Print all the migrations and if they are applied
+`,
+}
+
+func init() {
+	CmdMigrate.Run = runMigrate
+	CmdMigrate.Flags = map[string]bool{
+		"-t": false,
+	}
+}
+
+func printMigratePrompt(flag string) {
+}
+
+func runMigrate(cmd *Command, args []string) {
+	num := checkFlags(cmd.Flags, args, printMigratePrompt)
+	if num == -1 {
+		return
+	}
+	args = args[num:]
+
+	var tableName string
+	if cmd.Flags["-t"] {
+		if len(args) < 1 {
+			fmt.Println("params error, please see xorm help migrate")
+			return
+		}
+		tableName = args[0]
+		args = args[1:]
+	}
+
+	if len(args) < 3 {
+		fmt.Println("params error, please see xorm help migrate")
+		return
+	}
+
+	action := "up"
+	if len(args) > 3 {
+		action = args[3]
+	}
+
+	var version int64 = -1
+	if len(args) > 4 {
+		v, err := strconv.ParseInt(args[4], 10, 64)
+		if err != nil {
+			logging.Error("%v", err)
+			return
+		}
+		version = v
+	}
+
+	dir, err := filepath.Abs(args[2])
+	if err != nil {
+		logging.Error("%v", err)
+		return
+	}
+
+	if !dirExists(dir) {
+		logging.Error("Migrations %v path is not exist", dir)
+		return
+	}
+
+	migrations, err := xorm.LoadMigrations(dir)
+	if err != nil {
+		logging.Error("%v", err)
+		return
+	}
+
+	Orm, err := xorm.NewEngine(args[0], args[1])
+	if err != nil {
+		logging.Error("%v", err)
+		return
+	}
+	defer Orm.Close()
+
+	migrator := xorm.NewMigrator(Orm, migrations...)
+	if tableName != "" {
+		migrator.TableName = tableName
+	}
+
+	switch action {
+	case "up":
+		if version == -1 {
+			err = migrator.Migrate()
+		} else {
+			err = migrator.MigrateTo(version)
+		}
+	case "down":
+		if version == -1 {
+			err = migrator.Rollback()
+		} else {
+			err = migrator.RollbackTo(version)
+		}
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			logging.Error("%v", err)
+			return
+		}
+		for _, s := range status {
+			if s.Applied {
+				fmt.Printf("%v\t%v\tapplied at %v\n", s.Version, s.Name, s.AppliedAt)
+			} else {
+				fmt.Printf("%v\t%v\tpending\n", s.Version, s.Name)
+			}
+		}
+		return
+	default:
+		fmt.Println("Unsupported action", action)
+		return
+	}
+
+	if err != nil {
+		logging.Error("%v", err)
+		return
+	}
+
+	current, err := migrator.Version()
+	if err != nil {
+		logging.Error("%v", err)
+		return
+	}
+	fmt.Println("current version is", current)
+}